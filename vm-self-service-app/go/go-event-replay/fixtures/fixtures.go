@@ -0,0 +1,210 @@
+// Package fixtures provides representative vCenter CloudEvents, as delivered
+// by the VMware Event Broker Appliance, built from govmomi types.
+package fixtures
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// CloudEvent is the structured-mode CloudEvent the event router posts to a
+// function. Handlers only read Data, the rest is kept for realism.
+type CloudEvent struct {
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            types.BaseEvent `json:"data"`
+}
+
+// Inventory referenced by the fixtures.
+var (
+	Datacenter = types.ManagedObjectReference{Type: "Datacenter", Value: "datacenter-2"}
+	Cluster    = types.ManagedObjectReference{Type: "ClusterComputeResource", Value: "domain-c7"}
+	Host       = types.ManagedObjectReference{Type: "HostSystem", Value: "host-21"}
+	Datastore  = types.ManagedObjectReference{Type: "Datastore", Value: "datastore-15"}
+	VM         = types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-54"}
+)
+
+const (
+	source  = "https://vcenter.local/sdk"
+	evtType = "com.vmware.event.router/event"
+	vmName  = "DC0_C0_RP0_VM0"
+)
+
+// alarmIDs are the MoRefs of the default vCenter alarm definitions.
+var alarmIDs = map[string]string{
+	"VM CPU Usage":            "alarm-6",
+	"VM Memory Usage":         "alarm-7",
+	"VM Storage Usage":        "alarm-11",
+	"Datastore usage on disk": "alarm-2",
+}
+
+// fixture builds a named event.
+type fixture func() CloudEvent
+
+var catalog = map[string]fixture{
+	"alarm-cpu-red":          func() CloudEvent { return VMAlarm("VM CPU Usage", "yellow", "red", VM) },
+	"alarm-cpu-green":        func() CloudEvent { return VMAlarm("VM CPU Usage", "red", "green", VM) },
+	"alarm-memory-red":       func() CloudEvent { return VMAlarm("VM Memory Usage", "yellow", "red", VM) },
+	"alarm-storage-red":      func() CloudEvent { return VMAlarm("VM Storage Usage", "yellow", "red", VM) },
+	"alarm-storage-yellow":   func() CloudEvent { return VMAlarm("VM Storage Usage", "green", "yellow", VM) },
+	"alarm-datastore-red":    func() CloudEvent { return DatastoreAlarm("Datastore usage on disk", "yellow", "red", Datastore) },
+	"vm-reconfigured-cpu":    func() CloudEvent { return VMReconfigured(VM, types.VirtualMachineConfigSpec{NumCPUs: 2}) },
+	"vm-reconfigured-memory": func() CloudEvent { return VMReconfigured(VM, types.VirtualMachineConfigSpec{MemoryMB: 2048}) },
+}
+
+// Names lists the available fixtures in sorted order.
+func Names() []string {
+	var names []string
+	for n := range catalog {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Get returns the named fixture.
+func Get(name string) (CloudEvent, error) {
+	f, ok := catalog[name]
+	if !ok {
+		return CloudEvent{}, fmt.Errorf("unknown fixture %q", name)
+	}
+
+	return f(), nil
+}
+
+// JSON returns the named fixture as a request body.
+func JSON(name string) ([]byte, error) {
+	ce, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(ce)
+}
+
+// VMAlarm is an AlarmStatusChangedEvent for an alarm on a VM.
+func VMAlarm(alarm, from, to string, vm types.ManagedObjectReference) CloudEvent {
+	var e types.AlarmStatusChangedEvent
+	fillEvent(&e.Event)
+	e.Vm = &types.VmEventArgument{
+		EntityEventArgument: types.EntityEventArgument{Name: vmName},
+		Vm:                  vm,
+	}
+	e.Alarm = alarmArg(alarm)
+	e.Source = entityArg("Datacenters", types.ManagedObjectReference{Type: "Folder", Value: "group-d1"})
+	e.Entity = entityArg(vmName, vm)
+	e.From = from
+	e.To = to
+	e.FullFormattedMessage = fmt.Sprintf("Alarm '%s' on %s changed from %s to %s", alarm, vmName, titleColor(from), titleColor(to))
+
+	return wrap("AlarmStatusChangedEvent", &e)
+}
+
+// DatastoreAlarm is an AlarmStatusChangedEvent for an alarm on a datastore.
+func DatastoreAlarm(alarm, from, to string, ds types.ManagedObjectReference) CloudEvent {
+	var e types.AlarmStatusChangedEvent
+	fillEvent(&e.Event)
+	e.Ds = &types.DatastoreEventArgument{
+		EntityEventArgument: types.EntityEventArgument{Name: "LocalDS_0"},
+		Datastore:           ds,
+	}
+	e.Alarm = alarmArg(alarm)
+	e.Source = entityArg("Datacenters", types.ManagedObjectReference{Type: "Folder", Value: "group-d1"})
+	e.Entity = entityArg("LocalDS_0", ds)
+	e.From = from
+	e.To = to
+	e.FullFormattedMessage = fmt.Sprintf("Alarm '%s' on LocalDS_0 changed from %s to %s", alarm, titleColor(from), titleColor(to))
+
+	return wrap("AlarmStatusChangedEvent", &e)
+}
+
+// VMReconfigured is a VmReconfiguredEvent carrying the applied spec.
+func VMReconfigured(vm types.ManagedObjectReference, spec types.VirtualMachineConfigSpec) CloudEvent {
+	var e types.VmReconfiguredEvent
+	fillEvent(&e.Event)
+	e.Vm = &types.VmEventArgument{
+		EntityEventArgument: types.EntityEventArgument{Name: vmName},
+		Vm:                  vm,
+	}
+	e.ConfigSpec = spec
+	e.FullFormattedMessage = fmt.Sprintf("Reconfigured %s on %s in DC0", vmName, "DC0_C0_H0")
+
+	return wrap("VmReconfiguredEvent", &e)
+}
+
+// fillEvent sets the inventory and bookkeeping fields every vCenter event has.
+func fillEvent(e *types.Event) {
+	e.Key = 9001
+	e.ChainId = 9001
+	e.CreatedTime = time.Date(2020, 9, 29, 17, 0, 0, 0, time.UTC)
+	e.UserName = "VSPHERE.LOCAL\\Administrator"
+	e.Datacenter = &types.DatacenterEventArgument{
+		EntityEventArgument: types.EntityEventArgument{Name: "DC0"},
+		Datacenter:          Datacenter,
+	}
+	e.ComputeResource = &types.ComputeResourceEventArgument{
+		EntityEventArgument: types.EntityEventArgument{Name: "DC0_C0"},
+		ComputeResource:     Cluster,
+	}
+	e.Host = &types.HostEventArgument{
+		EntityEventArgument: types.EntityEventArgument{Name: "DC0_C0_H0"},
+		Host:                Host,
+	}
+}
+
+func alarmArg(name string) types.AlarmEventArgument {
+	return types.AlarmEventArgument{
+		EntityEventArgument: types.EntityEventArgument{Name: name},
+		Alarm:               types.ManagedObjectReference{Type: "Alarm", Value: alarmIDs[name]},
+	}
+}
+
+func entityArg(name string, ref types.ManagedObjectReference) types.ManagedEntityEventArgument {
+	return types.ManagedEntityEventArgument{
+		EntityEventArgument: types.EntityEventArgument{Name: name},
+		Entity:              ref,
+	}
+}
+
+// wrap puts the event in a CloudEvent. Its id is a hash of the event, so
+// it is stable and differs between fixtures with the same message.
+func wrap(subject string, data types.BaseEvent) CloudEvent {
+	e := data.GetEvent()
+	b, _ := json.Marshal(data)
+
+	return CloudEvent{
+		ID:              fmt.Sprintf("%08x", crc32.ChecksumIEEE(b)),
+		Source:          source,
+		SpecVersion:     "1.0",
+		Type:            evtType,
+		Subject:         subject,
+		Time:            e.CreatedTime,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+// titleColor matches how vCenter renders alarm states in messages.
+func titleColor(c string) string {
+	switch c {
+	case "green":
+		return "Green"
+	case "yellow":
+		return "Yellow"
+	case "red":
+		return "Red"
+	}
+
+	return "Gray"
+}
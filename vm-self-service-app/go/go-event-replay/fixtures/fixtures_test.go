@@ -0,0 +1,150 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestNames(t *testing.T) {
+	names := Names()
+	if len(names) != len(catalog) {
+		t.Fatalf("got %d names, want %d", len(names), len(catalog))
+	}
+
+	for i := 1; i < len(names); i++ {
+		if names[i-1] >= names[i] {
+			t.Errorf("names not sorted: %q before %q", names[i-1], names[i])
+		}
+	}
+}
+
+func TestAlarmFixtures(t *testing.T) {
+	tests := []struct {
+		name   string
+		alarm  string
+		from   string
+		to     string
+		entity types.ManagedObjectReference
+	}{
+		{"alarm-cpu-red", "VM CPU Usage", "yellow", "red", VM},
+		{"alarm-cpu-green", "VM CPU Usage", "red", "green", VM},
+		{"alarm-memory-red", "VM Memory Usage", "yellow", "red", VM},
+		{"alarm-storage-red", "VM Storage Usage", "yellow", "red", VM},
+		{"alarm-storage-yellow", "VM Storage Usage", "green", "yellow", VM},
+		{"alarm-datastore-red", "Datastore usage on disk", "yellow", "red", Datastore},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := JSON(tc.name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Decoded the way the handlers decode their events.
+			var ce struct {
+				ID          string
+				SpecVersion string `json:"specversion"`
+				Subject     string
+				Data        types.AlarmStatusChangedEvent
+			}
+			if err := json.Unmarshal(b, &ce); err != nil {
+				t.Fatalf("decoding fixture: %v", err)
+			}
+
+			if ce.ID == "" || ce.SpecVersion != "1.0" || ce.Subject != "AlarmStatusChangedEvent" {
+				t.Errorf("cloud event = %q %q %q, want an id, 1.0 and AlarmStatusChangedEvent", ce.ID, ce.SpecVersion, ce.Subject)
+			}
+
+			e := ce.Data
+			if e.Alarm.Name != tc.alarm || e.Alarm.Alarm.Value != alarmIDs[tc.alarm] || e.From != tc.from || e.To != tc.to {
+				t.Errorf("alarm = %s (%s) %s to %s, want %s (%s) %s to %s",
+					e.Alarm.Name, e.Alarm.Alarm.Value, e.From, e.To, tc.alarm, alarmIDs[tc.alarm], tc.from, tc.to)
+			}
+
+			if e.Entity.Entity != tc.entity {
+				t.Errorf("entity = %v, want %v", e.Entity.Entity, tc.entity)
+			}
+
+			switch tc.entity.Type {
+			case "VirtualMachine":
+				if e.Vm == nil || e.Vm.Vm != tc.entity || e.Ds != nil {
+					t.Errorf("vm argument = %v, datastore argument = %v, want vm %v", e.Vm, e.Ds, tc.entity)
+				}
+			case "Datastore":
+				if e.Ds == nil || e.Ds.Datastore != tc.entity || e.Vm != nil {
+					t.Errorf("datastore argument = %v, vm argument = %v, want datastore %v", e.Ds, e.Vm, tc.entity)
+				}
+			}
+		})
+	}
+}
+
+func TestReconfiguredFixtures(t *testing.T) {
+	tests := []struct {
+		name string
+		spec types.VirtualMachineConfigSpec
+	}{
+		{"vm-reconfigured-cpu", types.VirtualMachineConfigSpec{NumCPUs: 2}},
+		{"vm-reconfigured-memory", types.VirtualMachineConfigSpec{MemoryMB: 2048}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := JSON(tc.name)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var ce struct {
+				Subject string
+				Data    types.VmReconfiguredEvent
+			}
+			if err := json.Unmarshal(b, &ce); err != nil {
+				t.Fatalf("decoding fixture: %v", err)
+			}
+
+			if ce.Subject != "VmReconfiguredEvent" {
+				t.Errorf("subject = %q, want VmReconfiguredEvent", ce.Subject)
+			}
+
+			if ce.Data.Vm == nil || ce.Data.Vm.Vm != VM {
+				t.Errorf("vm argument = %v, want %v", ce.Data.Vm, VM)
+			}
+
+			if !reflect.DeepEqual(ce.Data.ConfigSpec, tc.spec) {
+				t.Errorf("config spec = %+v, want %+v", ce.Data.ConfigSpec, tc.spec)
+			}
+		})
+	}
+}
+
+func TestFixturesAreStable(t *testing.T) {
+	ids := make(map[string]string)
+	for _, n := range Names() {
+		a, err := JSON(n)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", n, err)
+		}
+
+		b, _ := JSON(n)
+		if string(a) != string(b) {
+			t.Errorf("%s differs between calls", n)
+		}
+
+		ce, _ := Get(n)
+		if other, ok := ids[ce.ID]; ok {
+			t.Errorf("%s has the same id as %s", n, other)
+		}
+		ids[ce.ID] = n
+	}
+}
+
+func TestUnknownFixture(t *testing.T) {
+	if _, err := JSON("no-such-fixture"); err == nil || err.Error() != `unknown fixture "no-such-fixture"` {
+		t.Errorf("error = %v, want unknown fixture", err)
+	}
+}
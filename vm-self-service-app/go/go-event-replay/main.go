@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pksrc/vebafn/vm-self-service-app/go/go-event-replay/fixtures"
+	"github.com/pksrc/vebafn/vm-self-service-app/go/go-event-replay/function"
+	"github.com/pksrc/vebafn/vm-self-service-app/go/go-event-replay/replay"
+)
+
+const usage = `Usage:
+  replay list                      list the built-in fixtures
+  replay show <fixture>            print a fixture as JSON
+  replay send -url <function url> [-file events.jsonl] [fixture ...]
+                                   POST fixtures and/or a recorded stream
+  replay send -local [-secrets dir] [-file events.jsonl] [fixture ...]
+                                   call the handler linked as function in-process
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		for _, n := range fixtures.Names() {
+			fmt.Println(n)
+		}
+	case "show":
+		err = show(os.Args[2:])
+	case "send":
+		err = send(os.Args[2:])
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func show(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("show takes exactly one fixture name")
+	}
+
+	b, err := fixtures.JSON(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+func send(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	url := fs.String("url", os.Getenv("REPLAY_URL"), "function URL, e.g. https://veba.yourdomain.com/function/vm-config-tagger-fn")
+	file := fs.String("file", "", "JSONL file with one recorded CloudEvent per line")
+	timeout := fs.Duration("timeout", 2*time.Minute, "timeout per request")
+	verbose := fs.Bool("v", false, "print full response bodies")
+	local := fs.Bool("local", false, "call the handler linked as function in-process instead of posting to -url")
+	secrets := fs.String("secrets", "secrets", "directory with the function's secrets for -local, used in place of /var/openfaas/secrets")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *url == "" && !*local {
+		return fmt.Errorf("a function url is required, set -url or REPLAY_URL, or use -local")
+	}
+
+	events, err := loadEvents(*file, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	if len(events) == 0 {
+		return fmt.Errorf("nothing to send, name a fixture or pass -file")
+	}
+
+	var inv replay.Invoker = replay.HTTPInvoker{URL: *url, Client: &http.Client{Timeout: *timeout}}
+	if *local {
+		dir, err := filepath.Abs(*secrets)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("secrets directory: %w", err)
+		}
+
+		// Handlers read their secrets from secret_mount_path on every invocation.
+		os.Setenv("secret_mount_path", dir)
+		inv = replay.FuncInvoker(function.Handle)
+	}
+
+	results := replay.Run(context.Background(), inv, events)
+	replay.Report(os.Stdout, results, *verbose)
+
+	for _, r := range results {
+		if r.Err != nil || r.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("one or more events failed")
+		}
+	}
+
+	return nil
+}
+
+// loadEvents collects the named fixtures followed by the events in file.
+func loadEvents(file string, names []string) ([]replay.Event, error) {
+	var events []replay.Event
+
+	for _, n := range names {
+		b, err := fixtures.JSON(n)
+		if err != nil {
+			return nil, fmt.Errorf("%w, available: %s", err, strings.Join(fixtures.Names(), ", "))
+		}
+		events = append(events, replay.Event{Name: n, Body: b})
+	}
+
+	if file == "" {
+		return events, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	recorded, err := replay.ReadJSONL(f, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(events, recorded...), nil
+}
@@ -0,0 +1,185 @@
+// Package replay sends CloudEvents to a function, over HTTP or by calling its
+// Handle in-process, and collects the responses.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	handler "github.com/openfaas/templates-sdk/go-http"
+)
+
+// Event is one request body to replay. Name identifies it in the report.
+type Event struct {
+	Name string
+	Body []byte
+}
+
+// Result is the outcome of replaying one Event.
+type Result struct {
+	Name       string
+	StatusCode int
+	Body       []byte
+	Duration   time.Duration
+	Err        error
+}
+
+// Invoker delivers a request body to a function.
+type Invoker interface {
+	Invoke(ctx context.Context, body []byte) (int, []byte, error)
+}
+
+// HTTPInvoker posts events to a function URL, e.g.
+// http://gateway:8080/function/vm-config-tagger-fn.
+type HTTPInvoker struct {
+	URL    string
+	Client *http.Client
+}
+
+// Invoke implements Invoker.
+func (h HTTPInvoker) Invoke(ctx context.Context, body []byte) (int, []byte, error) {
+	clt := h.Client
+	if clt == nil {
+		clt = http.DefaultClient
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+
+	res, err := clt.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("posting event: %w", err)
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	return res.StatusCode, resBody, nil
+}
+
+// FuncInvoker calls a golang-http Handle function in-process.
+type FuncInvoker func(handler.Request) (handler.Response, error)
+
+// Invoke implements Invoker. Handle's error is reported alongside the
+// response it returned, the same way the golang-http template does.
+func (f FuncInvoker) Invoke(ctx context.Context, body []byte) (int, []byte, error) {
+	res, err := f(handler.Request{
+		Body:   body,
+		Header: http.Header{"Content-Type": []string{"application/cloudevents+json"}},
+		Method: http.MethodPost,
+	})
+
+	status := res.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return status, res.Body, err
+}
+
+// Run replays events in order and returns one Result per event.
+func Run(ctx context.Context, inv Invoker, events []Event) []Result {
+	var results []Result
+
+	for _, e := range events {
+		start := time.Now()
+		status, body, err := inv.Invoke(ctx, e.Body)
+
+		results = append(results, Result{
+			Name:       e.Name,
+			StatusCode: status,
+			Body:       body,
+			Duration:   time.Since(start),
+			Err:        err,
+		})
+	}
+
+	return results
+}
+
+// ReadJSONL reads a recorded stream with one CloudEvent per line. Events are
+// named by their CloudEvent id, or by line number when there is none.
+func ReadJSONL(r io.Reader, name string) ([]Event, error) {
+	var events []Event
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		var ce struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(b, &ce); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid json: %w", name, line, err)
+		}
+
+		evtName := ce.ID
+		if evtName == "" {
+			evtName = fmt.Sprintf("%s:%d", name, line)
+		}
+
+		events = append(events, Event{Name: evtName, Body: append([]byte(nil), b...)})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+
+	return events, nil
+}
+
+// Report writes a line per result, followed by a summary.
+func Report(w io.Writer, results []Result, verbose bool) {
+	failed := 0
+
+	for _, r := range results {
+		status := fmt.Sprint(r.StatusCode)
+		if r.Err != nil || r.StatusCode >= http.StatusBadRequest {
+			failed++
+		}
+		if r.Err != nil {
+			status = fmt.Sprintf("%s (%v)", status, r.Err)
+		}
+
+		fmt.Fprintf(w, "%-28s %-8s %8s  %s\n", r.Name, status, r.Duration.Round(time.Millisecond), firstLine(r.Body))
+		if verbose {
+			fmt.Fprintf(w, "%s\n\n", r.Body)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d events replayed, %d failed.\n", len(results), failed)
+}
+
+func firstLine(b []byte) string {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+
+	const max = 80
+	if len(b) > max {
+		return string(b[:max]) + "..."
+	}
+
+	return string(b)
+}
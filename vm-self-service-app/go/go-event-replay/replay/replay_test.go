@@ -0,0 +1,258 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	handler "github.com/openfaas/templates-sdk/go-http"
+)
+
+func TestReadJSONL(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []Event
+		wantErr string
+	}{
+		{"empty", "", nil, ""},
+		{
+			"named by id",
+			`{"id":"a","data":{}}` + "\n" + `{"id":"b"}` + "\n",
+			[]Event{{Name: "a", Body: []byte(`{"id":"a","data":{}}`)}, {Name: "b", Body: []byte(`{"id":"b"}`)}},
+			"",
+		},
+		{
+			"named by line without id",
+			"\n" + `{"data":{}}` + "\n",
+			[]Event{{Name: "events.jsonl:2", Body: []byte(`{"data":{}}`)}},
+			"",
+		},
+		{
+			"blank lines and spaces skipped",
+			"  \n\t" + `{"id":"a"}` + "  \r\n\n",
+			[]Event{{Name: "a", Body: []byte(`{"id":"a"}`)}},
+			"",
+		},
+		{"invalid json", `{"id":"a"}` + "\n{\n", nil, "events.jsonl:2: invalid json"},
+		{"not an object", `["a"]`, nil, "events.jsonl:1: invalid json"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ReadJSONL(strings.NewReader(tc.input), "events.jsonl")
+			if tc.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.wantErr) {
+					t.Errorf("error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("events = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadJSONLLongLine(t *testing.T) {
+	long := `{"id":"long","data":"` + strings.Repeat("x", 1<<20) + `"}`
+
+	got, err := ReadJSONL(strings.NewReader(long), "events.jsonl")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(got) != 1 || got[0].Name != "long" || len(got[0].Body) != len(long) {
+		t.Errorf("got %d event(s), want the long one", len(got))
+	}
+}
+
+func TestRunHTTP(t *testing.T) {
+	var gotTypes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTypes = append(gotTypes, r.Header.Get("Content-Type"))
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		switch string(body) {
+		case "ok":
+			w.Write([]byte("done\nmore"))
+		case "bad":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid event"))
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+		wantErr    bool
+	}{
+		{"ok", "ok", http.StatusOK, "done\nmore", false},
+		{"rejected", "bad", http.StatusBadRequest, "invalid event", false},
+		{"timed out", "slow", 0, "", true},
+	}
+
+	inv := HTTPInvoker{URL: srv.URL, Client: &http.Client{Timeout: 100 * time.Millisecond}}
+	var events []Event
+	for _, tc := range tests {
+		events = append(events, Event{Name: tc.name, Body: []byte(tc.body)})
+	}
+
+	results := Run(context.Background(), inv, events)
+	if len(results) != len(tests) {
+		t.Fatalf("got %d results, want %d", len(results), len(tests))
+	}
+
+	for i, tc := range tests {
+		r := results[i]
+		if r.Name != tc.name || r.StatusCode != tc.wantStatus || string(r.Body) != tc.wantBody || (r.Err != nil) != tc.wantErr {
+			t.Errorf("%s: result = %q %d %q %v, want %d %q, error %t", tc.name, r.Name, r.StatusCode, r.Body, r.Err, tc.wantStatus, tc.wantBody, tc.wantErr)
+		}
+	}
+
+	for _, ct := range gotTypes {
+		if ct != "application/cloudevents+json" {
+			t.Errorf("content type = %q, want application/cloudevents+json", ct)
+		}
+	}
+}
+
+func TestRunUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	results := Run(context.Background(), HTTPInvoker{URL: url}, []Event{{Name: "e", Body: []byte("{}")}})
+	if len(results) != 1 || results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "posting event") {
+		t.Errorf("results = %+v, want a posting error", results)
+	}
+}
+
+func TestRunFunc(t *testing.T) {
+	errHandle := errors.New("handle failed")
+
+	tests := []struct {
+		name       string
+		res        handler.Response
+		err        error
+		wantStatus int
+		wantErr    error
+	}{
+		{"default status", handler.Response{Body: []byte("done")}, nil, http.StatusOK, nil},
+		{"status kept", handler.Response{Body: []byte("done"), StatusCode: http.StatusAccepted}, nil, http.StatusAccepted, nil},
+		{"error with response", handler.Response{Body: []byte("failed"), StatusCode: http.StatusInternalServerError}, errHandle, http.StatusInternalServerError, errHandle},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got handler.Request
+			inv := FuncInvoker(func(req handler.Request) (handler.Response, error) {
+				got = req
+				return tc.res, tc.err
+			})
+
+			results := Run(context.Background(), inv, []Event{{Name: tc.name, Body: []byte(`{"id":"a"}`)}})
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+
+			r := results[0]
+			if r.StatusCode != tc.wantStatus || !bytes.Equal(r.Body, tc.res.Body) || r.Err != tc.wantErr {
+				t.Errorf("result = %d %q %v, want %d %q %v", r.StatusCode, r.Body, r.Err, tc.wantStatus, tc.res.Body, tc.wantErr)
+			}
+
+			if got.Method != http.MethodPost || string(got.Body) != `{"id":"a"}` || got.Header.Get("Content-Type") != "application/cloudevents+json" {
+				t.Errorf("request = %s %q %v, want the event posted as a cloud event", got.Method, got.Body, got.Header)
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []Result
+		verbose bool
+		want    []string
+	}{
+		{
+			"all passed",
+			[]Result{{Name: "a", StatusCode: 200, Body: []byte("done\nmore"), Duration: 1500 * time.Microsecond}},
+			false,
+			[]string{
+				"a                            200           2ms  done",
+				"",
+				"1 events replayed, 0 failed.",
+			},
+		},
+		{
+			"failures counted",
+			[]Result{
+				{Name: "a", StatusCode: 500, Body: []byte("failed")},
+				{Name: "b", Err: errors.New("posting event: refused")},
+				{Name: "c", StatusCode: 200},
+			},
+			false,
+			[]string{
+				"a                            500            0s  failed",
+				"b                            0 (posting event: refused)       0s  ",
+				"c                            200            0s  ",
+				"",
+				"3 events replayed, 2 failed.",
+			},
+		},
+		{
+			"long body cut",
+			[]Result{{Name: "a", StatusCode: 200, Body: []byte(strings.Repeat("x", 100))}},
+			false,
+			[]string{
+				"a                            200            0s  " + strings.Repeat("x", 80) + "...",
+				"",
+				"1 events replayed, 0 failed.",
+			},
+		},
+		{
+			"verbose",
+			[]Result{{Name: "a", StatusCode: 200, Body: []byte("done\nmore")}},
+			true,
+			[]string{
+				"a                            200            0s  done",
+				"done",
+				"more",
+				"",
+				"",
+				"1 events replayed, 0 failed.",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			Report(&buf, tc.results, tc.verbose)
+
+			if got := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("report =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
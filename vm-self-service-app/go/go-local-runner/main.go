@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pksrc/vebafn/vm-self-service-app/go/go-local-runner/function"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "address to listen on")
+	secrets := flag.String("secrets", "secrets", "directory with the function's secrets, used in place of /var/openfaas/secrets")
+	watch := flag.Bool("watch", false, "rebuild and restart when the handler source changes")
+	flag.Parse()
+
+	dir, err := filepath.Abs(*secrets)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		log.Fatalf("secrets directory: %v", err)
+	}
+
+	// Handlers read their secrets from secret_mount_path on every invocation.
+	os.Setenv("secret_mount_path", dir)
+
+	if *watch {
+		err = supervise()
+	} else {
+		go watchSecrets(dir)
+		err = serve(*addr, function.Handle)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	handler "github.com/openfaas/templates-sdk/go-http"
+)
+
+// handleFunc is the golang-http function contract.
+type handleFunc func(handler.Request) (handler.Response, error)
+
+// serve runs fn behind an HTTP server the way the golang-http template does,
+// until interrupted.
+func serve(addr string, fn handleFunc) error {
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      makeRequestHandler(fn),
+		ReadTimeout:  2 * time.Minute,
+		WriteTimeout: 2 * time.Minute,
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- srv.Shutdown(ctx)
+	}()
+
+	log.Printf("Serving function on http://%s", addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return <-done
+}
+
+func makeRequestHandler(fn handleFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input []byte
+
+		if r.Body != nil {
+			defer r.Body.Close()
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			input = body
+		}
+
+		start := time.Now()
+		res, err := fn(handler.Request{
+			Body:        input,
+			Header:      r.Header,
+			Method:      r.Method,
+			QueryString: r.URL.RawQuery,
+			Host:        r.Host,
+		})
+
+		for k, v := range res.Header {
+			w.Header()[k] = v
+		}
+
+		status := res.StatusCode
+		if status == 0 {
+			status = http.StatusOK
+			if err != nil {
+				status = http.StatusInternalServerError
+			}
+		}
+
+		if err != nil {
+			log.Printf("%s %s: %d in %v: %v", r.Method, r.URL.Path, status, time.Since(start), err)
+		} else {
+			log.Printf("%s %s: %d in %v", r.Method, r.URL.Path, status, time.Since(start))
+		}
+
+		w.WriteHeader(status)
+		w.Write(res.Body)
+	}
+}
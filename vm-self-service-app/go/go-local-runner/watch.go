@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const pollInterval = time.Second
+
+// supervise builds the runner into a temporary binary, runs it without
+// -watch, and rebuilds and restarts it whenever the handler source changes.
+func supervise() error {
+	src, err := filepath.EvalSymlinks("function")
+	if err != nil {
+		return fmt.Errorf("resolving function directory, run from go-local-runner: %w", err)
+	}
+
+	tmp, err := ioutil.TempDir("", "go-local-runner")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	bin := filepath.Join(tmp, "fn")
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	var child *exec.Cmd
+	stop := func() {
+		if child == nil {
+			return
+		}
+		child.Process.Signal(os.Interrupt)
+		child.Wait()
+		child = nil
+	}
+	defer stop()
+
+	last := ""
+	for {
+		fp, err := fingerprint(src, ".go", ".mod", ".sum")
+		if err != nil {
+			return err
+		}
+
+		if fp != last {
+			last = fp
+			stop()
+
+			log.Printf("Building %s", src)
+			if child, err = start(bin); err != nil {
+				log.Printf("Build failed, waiting for changes: %v", err)
+			}
+		}
+
+		select {
+		case <-sig:
+			return nil
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// start builds the runner and starts it with the same flags, minus -watch.
+func start(bin string) (*exec.Cmd, error) {
+	build := exec.Command("go", "build", "-o", bin, ".")
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(bin, append(os.Args[1:], "-watch=false")...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
+}
+
+// watchSecrets logs when the secrets change. Handlers load their secrets on
+// every invocation, so changes apply from the next request.
+func watchSecrets(dir string) {
+	last, _ := fingerprint(dir)
+
+	for range time.Tick(pollInterval) {
+		fp, err := fingerprint(dir)
+		if err != nil {
+			log.Printf("Watching secrets: %v", err)
+			continue
+		}
+
+		if fp != last {
+			last = fp
+			log.Printf("Secrets in %s changed, they apply from the next invocation", dir)
+		}
+	}
+}
+
+// fingerprint summarises the names, sizes and modification times of the files
+// in dir with one of the given extensions, or of all files if none are given.
+func fingerprint(dir string, exts ...string) (string, error) {
+	h := sha1.New()
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !hasExt(path, exts) {
+			return nil
+		}
+
+		fmt.Fprintf(h, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+
+	return fmt.Sprintf("%x", h.Sum(nil)), err
+}
+
+func hasExt(path string, exts []string) bool {
+	if len(exts) == 0 {
+		return true
+	}
+
+	for _, e := range exts {
+		if strings.HasSuffix(path, e) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	govmomiproperty "github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// maxPresets is the most tags a generator may expand to.
+const maxPresets = 1000
+
+var (
+	generatorRE = regexp.MustCompile(`^(\w+)\((.*)\)$`)
+	rangeRE     = regexp.MustCompile(`^(-?\d+)\s*\.\.\s*(-?\d+)(?:\s+step\s+(\d+))?$`)
+)
+
+// expandPresets expands the comma separated presets of a property into tag
+// names, dropping repeats. A preset is a value, or a generator:
+//
+//	pow2(1024..65536)    powers of two from 1024 to 65536
+//	range(1..16 step 2)  1, 3, 5 and so on up to 16, step defaulting to 1
+//	inventory()          the values the property has in the inventory
+func expandPresets(ctx context.Context, vsc *vsClient, prop property, input string) ([]string, error) {
+	var presets []string
+	seen := make(map[string]bool)
+
+	for _, item := range strings.Split(input, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		values := []string{item}
+		if m := generatorRE.FindStringSubmatch(item); m != nil {
+			var err error
+			if values, err = generate(ctx, vsc, prop, m[1], strings.TrimSpace(m[2])); err != nil {
+				return nil, fmt.Errorf("%s: %w", item, err)
+			}
+		}
+
+		for _, v := range values {
+			if err := prop.validPreset(v); err != nil {
+				return nil, err
+			}
+
+			if !seen[v] {
+				seen[v] = true
+				presets = append(presets, v)
+			}
+		}
+	}
+
+	if len(presets) > maxPresets {
+		return nil, fmt.Errorf("%d presets, more than the %d allowed", len(presets), maxPresets)
+	}
+
+	return presets, nil
+}
+
+// generate returns the values of the generator called name with args.
+func generate(ctx context.Context, vsc *vsClient, prop property, name, args string) ([]string, error) {
+	switch name {
+	case "pow2":
+		from, to, step, err := parseRange(args)
+		if err != nil {
+			return nil, err
+		}
+		if step != 1 {
+			return nil, errors.New("pow2 takes no step")
+		}
+
+		return powersOfTwo(from, to)
+	case "range":
+		from, to, step, err := parseRange(args)
+		if err != nil {
+			return nil, err
+		}
+
+		return numberRange(from, to, step)
+	case "inventory":
+		if args != "" {
+			return nil, errors.New("inventory takes no arguments")
+		}
+
+		return vsc.inventoryValues(ctx, prop)
+	}
+
+	return nil, fmt.Errorf("unknown generator %s, want pow2, range or inventory", name)
+}
+
+// parseRange parses "from..to" with an optional " step n".
+func parseRange(s string) (from, to, step int64, err error) {
+	m := rangeRE.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, 0, fmt.Errorf("%q is not a range like 1..16 or 1..16 step 2", s)
+	}
+
+	if from, err = strconv.ParseInt(m[1], 10, 64); err != nil {
+		return 0, 0, 0, err
+	}
+	if to, err = strconv.ParseInt(m[2], 10, 64); err != nil {
+		return 0, 0, 0, err
+	}
+
+	step = 1
+	if m[3] != "" {
+		if step, err = strconv.ParseInt(m[3], 10, 64); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	switch {
+	case from > to:
+		return 0, 0, 0, fmt.Errorf("range %d..%d is empty", from, to)
+	case step < 1:
+		return 0, 0, 0, errors.New("step must be at least 1")
+	}
+
+	return from, to, step, nil
+}
+
+// numberRange returns the numbers from from up to to, step apart.
+func numberRange(from, to, step int64) ([]string, error) {
+	// The span of a range can be wider than an int64 holds, but not than a
+	// uint64.
+	steps := (uint64(to) - uint64(from)) / uint64(step)
+	if steps >= maxPresets {
+		return nil, fmt.Errorf("more than %d values", maxPresets)
+	}
+	count := steps + 1
+
+	values := make([]string, 0, count)
+	for i := uint64(0); i < count; i++ {
+		n := int64(uint64(from) + i*uint64(step))
+		values = append(values, strconv.FormatInt(n, 10))
+	}
+
+	return values, nil
+}
+
+// powersOfTwo returns the powers of two from from up to to.
+func powersOfTwo(from, to int64) ([]string, error) {
+	var values []string
+	for n := int64(1); n <= to && n > 0; n *= 2 {
+		if n >= from {
+			values = append(values, strconv.FormatInt(n, 10))
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no power of two from %d to %d", from, to)
+	}
+
+	return values, nil
+}
+
+// inventoryValues returns the distinct values the property has on the objects
+// of its type in the inventory, in order.
+func (vsc *vsClient) inventoryValues(ctx context.Context, prop property) ([]string, error) {
+	v, err := view.NewManager(vsc.govmomi.Client).CreateContainerView(ctx, vsc.govmomi.ServiceContent.RootFolder, []string{prop.ObjectType}, true)
+	if err != nil {
+		return nil, fmt.Errorf("creating inventory view: %w", err)
+	}
+	defer v.Destroy(ctx)
+
+	refs, err := v.Find(ctx, []string{prop.ObjectType}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("listing %s objects: %w", prop.ObjectType, err)
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("no %s in the inventory", prop.ObjectType)
+	}
+
+	var content []types.ObjectContent
+	pc := govmomiproperty.DefaultCollector(vsc.govmomi.Client)
+	if err := pc.Retrieve(ctx, refs, []string{prop.Path}, &content); err != nil {
+		return nil, fmt.Errorf("retrieving %s: %w", prop, err)
+	}
+
+	seen := make(map[string]bool)
+	var values []string
+	for _, oc := range content {
+		for _, dp := range oc.PropSet {
+			if s := fmt.Sprint(dp.Val); dp.Val != nil && !seen[s] {
+				seen[s] = true
+				values = append(values, s)
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no %s object has %s set", prop.ObjectType, prop.Path)
+	}
+
+	sortValues(values, prop.kind)
+
+	return values, nil
+}
+
+// sortValues sorts numbers by value, and anything else as text.
+func sortValues(values []string, kind reflect.Kind) {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+		sort.SliceStable(values, func(i, j int) bool {
+			a, _ := strconv.ParseFloat(values[i], 64)
+			b, _ := strconv.ParseFloat(values[j], 64)
+			return a < b
+		})
+	default:
+		sort.Strings(values)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in             string
+		from, to, step int64
+		wantErr        bool
+	}{
+		{in: "1..16", from: 1, to: 16, step: 1},
+		{in: "1 .. 16 step 2", from: 1, to: 16, step: 2},
+		{in: "-8..8", from: -8, to: 8, step: 1},
+		{in: "9223372036854775800..9223372036854775807", from: 9223372036854775800, to: 9223372036854775807, step: 1},
+		{in: "16..1", wantErr: true},
+		{in: "1..16 step 0", wantErr: true},
+		{in: "1..99999999999999999999", wantErr: true},
+		{in: "1..16 step 99999999999999999999", wantErr: true},
+		{in: "1-16", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		from, to, step, err := parseRange(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseRange(%q) error = %v, want error %t", tc.in, err, tc.wantErr)
+			continue
+		}
+
+		if !tc.wantErr && (from != tc.from || to != tc.to || step != tc.step) {
+			t.Errorf("parseRange(%q) = %d, %d, %d, want %d, %d, %d", tc.in, from, to, step, tc.from, tc.to, tc.step)
+		}
+	}
+}
+
+func TestNumberRange(t *testing.T) {
+	tests := []struct {
+		name           string
+		from, to, step int64
+		want           []string
+		wantErr        bool
+	}{
+		{name: "step 1", from: 1, to: 4, step: 1, want: []string{"1", "2", "3", "4"}},
+		{name: "step 2", from: 1, to: 16, step: 2, want: []string{"1", "3", "5", "7", "9", "11", "13", "15"}},
+		{name: "single", from: 5, to: 5, step: 3, want: []string{"5"}},
+		{name: "negative", from: -2, to: 2, step: 2, want: []string{"-2", "0", "2"}},
+		{name: "near max", from: 9223372036854775805, to: 9223372036854775807, step: 1, want: []string{"9223372036854775805", "9223372036854775806", "9223372036854775807"}},
+		{name: "step past max", from: 9223372036854775800, to: 9223372036854775807, step: 5, want: []string{"9223372036854775800", "9223372036854775805"}},
+		{name: "whole int64", from: -9223372036854775808, to: 9223372036854775807, step: 4611686018427387904, want: []string{"-9223372036854775808", "-4611686018427387904", "0", "4611686018427387904"}},
+		{name: "too many", from: 1, to: 1001, step: 1, wantErr: true},
+		{name: "too wide", from: -9223372036854775808, to: 9223372036854775807, step: 1, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := numberRange(tc.from, tc.to, tc.step)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("numberRange() error = %v, want error %t", err, tc.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("numberRange() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPowersOfTwo(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to int64
+		want     []string
+		wantErr  bool
+	}{
+		{name: "memory", from: 1024, to: 16384, want: []string{"1024", "2048", "4096", "8192", "16384"}},
+		{name: "bounds between powers", from: 3, to: 20, want: []string{"4", "8", "16"}},
+		{name: "from below one", from: -4, to: 2, want: []string{"1", "2"}},
+		{name: "up to max", from: 4611686018427387904, to: 9223372036854775807, want: []string{"4611686018427387904"}},
+		{name: "none", from: 5, to: 7, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := powersOfTwo(tc.from, tc.to)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("powersOfTwo() error = %v, want error %t", err, tc.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("powersOfTwo() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
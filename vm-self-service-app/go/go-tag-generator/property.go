@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// objectTypes are the managed object types taggen creates categories for.
+var objectTypes = map[string]reflect.Type{
+	"VirtualMachine":         reflect.TypeOf(mo.VirtualMachine{}),
+	"HostSystem":             reflect.TypeOf(mo.HostSystem{}),
+	"Datastore":              reflect.TypeOf(mo.Datastore{}),
+	"ClusterComputeResource": reflect.TypeOf(mo.ClusterComputeResource{}),
+}
+
+// interfaceTypes are the types of the interface-typed properties whose type
+// depends on the object, keyed by "ObjectType:path". Other interface-typed
+// properties are taken to have the type the interface is named after, e.g.
+// DatastoreInfo for BaseDatastoreInfo.
+var interfaceTypes = map[string]reflect.Type{
+	"ClusterComputeResource:summary":         reflect.TypeOf(types.ClusterComputeResourceSummary{}),
+	"ClusterComputeResource:configurationEx": reflect.TypeOf(types.ClusterConfigInfoEx{}),
+}
+
+// property is a property of a managed object type that tags are created for.
+type property struct {
+	ObjectType string
+	Path       string
+	// kind is the kind of the property's value.
+	kind reflect.Kind
+}
+
+// parseProperty parses "[ObjectType:]path", e.g.
+// "HostSystem:summary.hardware.numCpuCores", checking the path against the
+// govmomi type of the object. The object type defaults to VirtualMachine.
+func parseProperty(s string) (property, error) {
+	p := property{ObjectType: "VirtualMachine", Path: s}
+	if i := strings.Index(s, ":"); i >= 0 {
+		p.ObjectType, p.Path = s[:i], s[i+1:]
+	}
+
+	t, ok := objectTypes[p.ObjectType]
+	if !ok {
+		return property{}, fmt.Errorf("unknown object type %q, want VirtualMachine, HostSystem, Datastore or ClusterComputeResource", p.ObjectType)
+	}
+
+	kind, err := propertyKind(p.ObjectType, t, p.Path)
+	if err != nil {
+		return property{}, fmt.Errorf("%s of %s: %w", p.Path, p.ObjectType, err)
+	}
+	p.kind = kind
+
+	return p, nil
+}
+
+// category is the name of the property's category. VM categories are named
+// after the path alone, as the config tagger expects.
+func (p property) category() string {
+	if p.ObjectType == "VirtualMachine" {
+		return p.Path
+	}
+
+	return p.ObjectType + "." + p.Path
+}
+
+func (p property) String() string {
+	if p.ObjectType == "VirtualMachine" {
+		return p.Path
+	}
+
+	return p.ObjectType + ":" + p.Path
+}
+
+// validPreset checks that the preset is a value of the property's kind.
+func (p property) validPreset(preset string) error {
+	var err error
+	switch p.kind {
+	case reflect.Bool:
+		_, err = strconv.ParseBool(preset)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		_, err = strconv.ParseInt(preset, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		_, err = strconv.ParseFloat(preset, 64)
+	}
+
+	if err != nil {
+		return fmt.Errorf("preset %q is not a %s value of %s", preset, p.kind, p)
+	}
+
+	return nil
+}
+
+// propertyKind walks the dotted path through the fields of t, the type of
+// objectType, named as in the vSphere API, and returns the kind of the value
+// it ends on. The value must be a scalar that a tag can name.
+func propertyKind(objectType string, t reflect.Type, path string) (reflect.Kind, error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		t = concreteType(objectType, strings.Join(names[:i], "."), t)
+		if t.Kind() != reflect.Struct {
+			return reflect.Invalid, fmt.Errorf("%s is not an object with properties", name)
+		}
+
+		f, ok := fieldByAPIName(t, name)
+		if !ok {
+			return reflect.Invalid, fmt.Errorf("no property %s in %s", name, t.Name())
+		}
+		t = f.Type
+	}
+
+	t = concreteType(objectType, path, t)
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Float32, reflect.Float64:
+		return t.Kind(), nil
+	}
+
+	return reflect.Invalid, fmt.Errorf("the value is a %s, not a string, number or boolean", t.Kind())
+}
+
+// concreteType returns the type of the value at path of objectType, given the
+// field type t: pointers are followed, and interfaces resolved to a vim25 type.
+func concreteType(objectType, path string, t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Interface {
+		return t
+	}
+
+	if ct, ok := interfaceTypes[objectType+":"+path]; ok {
+		return ct
+	}
+
+	if ct, ok := types.TypeFunc()(strings.TrimPrefix(t.Name(), "Base")); ok {
+		return ct
+	}
+
+	return t
+}
+
+// fieldByAPIName returns the field of struct t named name in the vSphere API,
+// looking into embedded structs. Depending on the govmomi version, managed
+// object fields carry the name in their mo or json tag.
+func fieldByAPIName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("mo")
+		if tag == "" {
+			tag = f.Tag.Get("xml")
+		}
+		if tag == "" {
+			tag = f.Tag.Get("json")
+		}
+		if strings.Split(tag, ",")[0] == name {
+			return f, true
+		}
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if ef, ok := fieldByAPIName(f.Type, name); ok {
+				return ef, true
+			}
+		}
+	}
+
+	return reflect.StructField{}, false
+}
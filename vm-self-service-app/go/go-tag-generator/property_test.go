@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProperty(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     property
+		category string
+		wantErr  bool
+	}{
+		{in: "config.hardware.memoryMB", want: property{ObjectType: "VirtualMachine", Path: "config.hardware.memoryMB", kind: reflect.Int32}, category: "config.hardware.memoryMB"},
+		{in: "VirtualMachine:config.memoryHotAddEnabled", want: property{ObjectType: "VirtualMachine", Path: "config.memoryHotAddEnabled", kind: reflect.Bool}, category: "config.memoryHotAddEnabled"},
+		{in: "config.latencySensitivity.level", want: property{ObjectType: "VirtualMachine", Path: "config.latencySensitivity.level", kind: reflect.String}, category: "config.latencySensitivity.level"},
+		{in: "HostSystem:summary.hardware.numCpuCores", want: property{ObjectType: "HostSystem", Path: "summary.hardware.numCpuCores", kind: reflect.Int16}, category: "HostSystem.summary.hardware.numCpuCores"},
+		{in: "HostSystem:name", want: property{ObjectType: "HostSystem", Path: "name", kind: reflect.String}, category: "HostSystem.name"},
+		{in: "Datastore:summary.type", want: property{ObjectType: "Datastore", Path: "summary.type", kind: reflect.String}, category: "Datastore.summary.type"},
+		{in: "Datastore:info.url", want: property{ObjectType: "Datastore", Path: "info.url", kind: reflect.String}, category: "Datastore.info.url"},
+		{in: "ClusterComputeResource:summary.numCpuCores", want: property{ObjectType: "ClusterComputeResource", Path: "summary.numCpuCores", kind: reflect.Int16}, category: "ClusterComputeResource.summary.numCpuCores"},
+		{in: "ClusterComputeResource:summary.numVmotions", want: property{ObjectType: "ClusterComputeResource", Path: "summary.numVmotions", kind: reflect.Int32}, category: "ClusterComputeResource.summary.numVmotions"},
+		{in: "ClusterComputeResource:configurationEx.drsConfig.enabled", want: property{ObjectType: "ClusterComputeResource", Path: "configurationEx.drsConfig.enabled", kind: reflect.Bool}, category: "ClusterComputeResource.configurationEx.drsConfig.enabled"},
+		{in: "ClusterComputeResource:configuration.dasConfig.enabled", want: property{ObjectType: "ClusterComputeResource", Path: "configuration.dasConfig.enabled", kind: reflect.Bool}, category: "ClusterComputeResource.configuration.dasConfig.enabled"},
+		{in: "Network:name", wantErr: true},
+		{in: "config.hardware.nope", wantErr: true},
+		{in: "config.hardware", wantErr: true},
+		{in: "config.hardware.device", wantErr: true},
+		{in: "HostSystem:summary.hardware.numCpuCores.x", wantErr: true},
+		{in: "ClusterComputeResource:summary.nope", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := parseProperty(tc.in)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("parseProperty() error = %v, want error %t", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			if got != tc.want {
+				t.Errorf("parseProperty() = %+v, want %+v", got, tc.want)
+			}
+
+			if c := got.category(); c != tc.category {
+				t.Errorf("category() = %q, want %q", c, tc.category)
+			}
+		})
+	}
+}
+
+func TestValidPreset(t *testing.T) {
+	tests := []struct {
+		kind    reflect.Kind
+		preset  string
+		wantErr bool
+	}{
+		{reflect.Int32, "4096", false},
+		{reflect.Int32, "4k", true},
+		{reflect.Bool, "true", false},
+		{reflect.Bool, "yes", true},
+		{reflect.Float64, "1.5", false},
+		{reflect.String, "anything", false},
+	}
+
+	for _, tc := range tests {
+		p := property{ObjectType: "VirtualMachine", Path: "x", kind: tc.kind}
+		if err := p.validPreset(tc.preset); (err != nil) != tc.wantErr {
+			t.Errorf("validPreset(%q) for %s error = %v, want error %t", tc.preset, tc.kind, err, tc.wantErr)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"strings"
@@ -29,6 +30,15 @@ type vcConfig struct {
 func main() {
 	ctx := context.Background()
 
+	// taggen export and taggen import move taxonomies between vCenters.
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, os.Args[1], os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfg, err := vcCredentials()
 	if err != nil {
 		fmt.Println(err)
@@ -41,18 +51,18 @@ func main() {
 		return
 	}
 
-	// These are config.hardware properties listed in vim25/types/types.go
+	// These are VM config properties listed in vim25/types/types.go
 	// vSphere API Doc: https://vdc-download.vmware.com/vmwb-repository/dcr-public/b50dcbbf-051d-4204-a3e7-e1b618c1e384/538cf2ec-b34f-4bae-a332-3820ef9e7773/vim.vm.VirtualHardware.html
 	hwProps := []string{
-		"numCPU",
-		"memoryMB",
-		"numCoresPerSocket",
-		"memoryHotAddEnabled",
-		"cpuHotRemoveEnabled",
-		"cpuHotAddEnabled",
+		"config.hardware.numCPU",
+		"config.hardware.memoryMB",
+		"config.hardware.numCoresPerSocket",
+		"config.memoryHotAddEnabled",
+		"config.cpuHotRemoveEnabled",
+		"config.cpuHotAddEnabled",
 	}
 
-	cfgTags, err := userSelectTags(hwProps)
+	cfgTags, err := userSelectTags(ctx, vsClt, hwProps)
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -65,32 +75,24 @@ func main() {
 }
 
 // TODO: if tag or category already exists, skip.
-func makeTags(ctx context.Context, vsc *vsClient, cfgTags map[string][]string) error {
+func makeTags(ctx context.Context, vsc *vsClient, cfgTags map[property][]string) error {
 
 	for t := range cfgTags {
-		preDesc := "Hardware configuration for "
-		preName := "config.hardware."
-
-		if t == "memoryHotAddEnabled" || t == "cpuHotRemoveEnabled" || t == "cpuHotAddEnabled" {
-			preDesc = "Configuration for "
-			preName = "config."
-		}
-
 		cID, err := vsc.tagManager.CreateCategory(ctx, &tags.Category{
-			AssociableTypes: []string{"VirtualMachine"},
+			AssociableTypes: []string{t.ObjectType},
 			Cardinality:     "SINGLE",
-			Description:     preDesc,
-			Name:            preName + t,
+			Description:     "Configuration for " + t.String(),
+			Name:            t.category(),
 		})
 		if err != nil {
 			return err
 		}
 
 		for _, p := range cfgTags[t] {
-			fmt.Println("Creating " + p + " tag for " + t)
+			fmt.Println("Creating " + p + " tag for " + t.String())
 			_, err := vsc.tagManager.CreateTag(ctx, &tags.Tag{
 				CategoryID:  cID,
-				Description: "Preset for " + t + " configuration",
+				Description: "Preset for " + t.String() + " configuration",
 				Name:        p,
 			})
 			if err != nil {
@@ -103,11 +105,11 @@ func makeTags(ctx context.Context, vsc *vsClient, cfgTags map[string][]string) e
 }
 
 // Ask user to choose which tags to generate.
-func userSelectTags(props []string) (map[string][]string, error) {
+func userSelectTags(ctx context.Context, vsc *vsClient, props []string) (map[property][]string, error) {
 	fmt.Printf("There are %d properties from which tags can be created. The properties are %+v.\n", len(props), props)
 	fmt.Printf("For each property, please indicate whether or not you want to create tags.\n")
 
-	cfgTags := make(map[string][]string)
+	cfgTags := make(map[property][]string)
 	reader := bufio.NewReader(os.Stdin)
 
 	// Ask user which of the properties should be made to tags.
@@ -120,31 +122,98 @@ func userSelectTags(props []string) (map[string][]string, error) {
 		}
 
 		if userInput == "Y\n" || userInput == "y\n" {
-			ps := setPresets(p)
-			cfgTags[p] = ps
+			prop, err := parseProperty(p)
+			if err != nil {
+				return nil, err
+			}
+
+			presets, err := userPresets(ctx, vsc, reader, prop, setPresets(p))
+			if err != nil {
+				return nil, err
+			}
+			cfgTags[prop] = presets
 		}
 	}
 
+	// Then any other property, of any of the object types.
+	for {
+		fmt.Println("Other property to create tags for, as [ObjectType:]path, e.g. config.latencySensitivity.level or HostSystem:summary.hardware.numCpuCores? Press enter when done.")
+
+		userInput, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		userInput = strings.TrimSpace(userInput)
+		if userInput == "" {
+			break
+		}
+
+		prop, err := parseProperty(userInput)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		presets, err := userPresets(ctx, vsc, reader, prop, nil)
+		if err != nil {
+			return nil, err
+		}
+		cfgTags[prop] = presets
+	}
+
 	fmt.Println("Tags to be created for ", cfgTags)
 
 	return cfgTags, nil
 }
 
+// userPresets asks for the presets of a property until they are all values
+// of it, expanding any generators. An empty answer keeps the defaults, if
+// there are any.
+func userPresets(ctx context.Context, vsc *vsClient, reader *bufio.Reader, prop property, defaults []string) ([]string, error) {
+	for {
+		fmt.Printf("Presets for %s, separated by commas? Generators pow2(1024..65536), range(1..16 step 2) and inventory() expand to several.\n", prop)
+		if len(defaults) > 0 {
+			fmt.Printf("Press enter for %s.\n", strings.Join(defaults, ", "))
+		}
+
+		userInput, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.TrimSpace(userInput) == "" && len(defaults) > 0 {
+			return defaults, nil
+		}
+
+		presets, err := expandPresets(ctx, vsc, prop, userInput)
+		switch {
+		case err != nil:
+			fmt.Println(err)
+		case len(presets) == 0:
+			fmt.Println("At least one preset is needed.")
+		default:
+			fmt.Printf("Presets for %s: %s\n", prop, strings.Join(presets, ", "))
+			return presets, nil
+		}
+	}
+}
+
 // TODO: allow users to set their own presets through yaml or csv files.
 func setPresets(prop string) []string {
 
 	switch prop {
-	case "numCPU":
+	case "config.hardware.numCPU":
 		return []string{"1", "2", "3", "4"}
-	case "memoryMB":
+	case "config.hardware.memoryMB":
 		return []string{"1024", "2048", "4096", "8192", "16384"}
-	case "numCoresPerSocket":
+	case "config.hardware.numCoresPerSocket":
 		return []string{"1", "2", "3", "4"}
-	case "memoryHotAddEnabled":
+	case "config.memoryHotAddEnabled":
 		return []string{"true", "false"}
-	case "cpuHotRemoveEnabled":
+	case "config.cpuHotRemoveEnabled":
 		return []string{"true", "false"}
-	case "cpuHotAddEnabled":
+	case "config.cpuHotAddEnabled":
 		return []string{"true", "false"}
 	}
 	return []string{}
@@ -185,10 +254,14 @@ func newClient(ctx context.Context, cfg vcConfig) (*vsClient, error) {
 
 // TODO: validate user input for vSphere host and credentials.
 func vcCredentials() (vcConfig, error) {
-	reader := bufio.NewReader(os.Stdin)
+	return promptCredentials(bufio.NewReader(os.Stdin), os.Stdout)
+}
 
-	fmt.Println("If credentials already set via environmental variables, press enter.")
-	fmt.Println("What is the vSphere server address, e.g. 10.152.128.165:443?")
+// promptCredentials asks for the credentials on out, reading the answers from
+// in. An empty answer takes the value of the environment variable.
+func promptCredentials(reader *bufio.Reader, out io.Writer) (vcConfig, error) {
+	fmt.Fprintln(out, "If credentials already set via environmental variables, press enter.")
+	fmt.Fprintln(out, "What is the vSphere server address, e.g. 10.152.128.165:443?")
 	server, err := reader.ReadString('\n')
 	server = strings.TrimSuffix(server, "\n")
 	if err != nil {
@@ -196,11 +269,11 @@ func vcCredentials() (vcConfig, error) {
 	}
 
 	if server == "" {
-		fmt.Print("Using environmental variable.\n\n")
+		fmt.Fprint(out, "Using environmental variable.\n\n")
 		server = os.Getenv("VEBA_TAG_GEN_SERVER")
 	}
 
-	fmt.Println("vSphere username, e.g. Administrator")
+	fmt.Fprintln(out, "vSphere username, e.g. Administrator")
 	user, err := reader.ReadString('\n')
 	user = strings.TrimSuffix(user, "\n")
 	if err != nil {
@@ -208,11 +281,11 @@ func vcCredentials() (vcConfig, error) {
 	}
 
 	if user == "" {
-		fmt.Print("Using environmental variable.\n\n")
+		fmt.Fprint(out, "Using environmental variable.\n\n")
 		user = os.Getenv("VEBA_TAG_GEN_USER")
 	}
 
-	fmt.Println("vSphere password")
+	fmt.Fprintln(out, "vSphere password")
 	pass, err := reader.ReadString('\n')
 	pass = strings.TrimSuffix(pass, "\n")
 	if err != nil {
@@ -220,17 +293,17 @@ func vcCredentials() (vcConfig, error) {
 	}
 
 	if pass == "" {
-		fmt.Print("Using environmental variable.\n\n")
+		fmt.Fprint(out, "Using environmental variable.\n\n")
 		pass = os.Getenv("VEBA_TAG_GEN_PASS")
 	}
 
 	cfg := vcConfig{server, user, pass, true}
 
 	if pass == "" || user == "" || server == "" {
-		fmt.Println("Unable to proceed without credentials.")
+		fmt.Fprintln(out, "Unable to proceed without credentials.")
 		return vcConfig{}, fmt.Errorf("pass set: %v, user set: %v, server set: %v", pass != "", user != "", server != "")
 	}
 
-	fmt.Println("Credentials have been set.")
+	fmt.Fprintln(out, "Credentials have been set.")
 	return cfg, nil
 }
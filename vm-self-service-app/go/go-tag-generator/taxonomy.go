@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/vapi/tags"
+	"gopkg.in/yaml.v2"
+)
+
+// taxonomy is the categories and tags of a vCenter, as exported.
+type taxonomy struct {
+	Categories []exportedCategory `json:"categories" yaml:"categories"`
+}
+
+// exportedCategory is a category and its tags. IDs are those on the vCenter
+// exported from, and are only used to map them to the IDs on import.
+type exportedCategory struct {
+	ID              string        `json:"id" yaml:"id"`
+	Name            string        `json:"name" yaml:"name"`
+	Description     string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Cardinality     string        `json:"cardinality" yaml:"cardinality"`
+	AssociableTypes []string      `json:"associable_types,omitempty" yaml:"associable_types,omitempty"`
+	Tags            []exportedTag `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+type exportedTag struct {
+	ID          string `json:"id" yaml:"id"`
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// runCommand runs the export or import command with its arguments.
+func runCommand(ctx context.Context, name string, args []string) error {
+	fs := flag.NewFlagSet("taggen "+name, flag.ContinueOnError)
+	format := fs.String("format", "", "yaml or json, by default from the file extension, else yaml")
+
+	var prefix, file, mapFile *string
+	switch name {
+	case "export":
+		prefix = fs.String("prefix", "", "only export categories whose name starts with it, e.g. config.*")
+		file = fs.String("o", "", "file to write to, by default stdout")
+	case "import":
+		file = fs.String("f", "", "file to read from, by default stdin")
+		mapFile = fs.String("map", "", "file to write the ID mapping to as JSON, by default stdout")
+	default:
+		return fmt.Errorf("unknown command %q, want export or import", name)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := fileFormat(*format, *file)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := envCredentials(name == "import" && *file == "")
+	if err != nil {
+		return err
+	}
+
+	vsc, err := newClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	if name == "export" {
+		t, err := exportTaxonomy(ctx, vsc, *prefix)
+		if err != nil {
+			return err
+		}
+
+		b, err := marshalTaxonomy(t, f)
+		if err != nil {
+			return err
+		}
+
+		return writeOutput(*file, b)
+	}
+
+	b, err := readInput(*file)
+	if err != nil {
+		return err
+	}
+
+	var t taxonomy
+	if err := unmarshalTaxonomy(b, f, &t); err != nil {
+		return err
+	}
+
+	ids, err := importTaxonomy(ctx, vsc, t)
+	if err != nil {
+		return err
+	}
+
+	m, err := json.MarshalIndent(ids, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeOutput(*mapFile, append(m, '\n'))
+}
+
+// fileFormat returns the format of the file: the given one, else the one of
+// its extension.
+func fileFormat(format, file string) (string, error) {
+	if format == "" {
+		format = "yaml"
+		if strings.EqualFold(filepath.Ext(file), ".json") {
+			format = "json"
+		}
+	}
+
+	if format != "yaml" && format != "json" {
+		return "", fmt.Errorf("unknown format %q, want yaml or json", format)
+	}
+
+	return format, nil
+}
+
+// envCredentials takes the credentials from the environment. When they aren't
+// all set it asks for them on stderr, so exports can be written to stdout,
+// unless stdin is the command's input.
+func envCredentials(stdinInput bool) (vcConfig, error) {
+	cfg := vcConfig{
+		server:   os.Getenv("VEBA_TAG_GEN_SERVER"),
+		user:     os.Getenv("VEBA_TAG_GEN_USER"),
+		password: os.Getenv("VEBA_TAG_GEN_PASS"),
+		insecure: true,
+	}
+
+	if cfg.server != "" && cfg.user != "" && cfg.password != "" {
+		return cfg, nil
+	}
+
+	if stdinInput {
+		return vcConfig{}, errors.New("reading from stdin needs the credentials in VEBA_TAG_GEN_SERVER, VEBA_TAG_GEN_USER and VEBA_TAG_GEN_PASS")
+	}
+
+	return promptCredentials(bufio.NewReader(os.Stdin), os.Stderr)
+}
+
+// exportTaxonomy returns the categories whose name starts with prefix, and
+// their tags, sorted by name. A trailing * in prefix is ignored.
+func exportTaxonomy(ctx context.Context, vsc *vsClient, prefix string) (taxonomy, error) {
+	prefix = strings.TrimSuffix(prefix, "*")
+
+	cats, err := vsc.tagManager.GetCategories(ctx)
+	if err != nil {
+		return taxonomy{}, fmt.Errorf("listing categories: %w", err)
+	}
+
+	sort.Slice(cats, func(i, j int) bool { return cats[i].Name < cats[j].Name })
+
+	var t taxonomy
+	for _, c := range cats {
+		if !strings.HasPrefix(c.Name, prefix) {
+			continue
+		}
+
+		ts, err := vsc.tagManager.GetTagsForCategory(ctx, c.ID)
+		if err != nil {
+			return taxonomy{}, fmt.Errorf("listing tags of %s: %w", c.Name, err)
+		}
+
+		sort.Slice(ts, func(i, j int) bool { return ts[i].Name < ts[j].Name })
+
+		ec := exportedCategory{
+			ID:              c.ID,
+			Name:            c.Name,
+			Description:     c.Description,
+			Cardinality:     c.Cardinality,
+			AssociableTypes: c.AssociableTypes,
+		}
+		for _, tag := range ts {
+			ec.Tags = append(ec.Tags, exportedTag{ID: tag.ID, Name: tag.Name, Description: tag.Description})
+		}
+
+		t.Categories = append(t.Categories, ec)
+	}
+
+	return t, nil
+}
+
+// importTaxonomy creates the categories and tags of t that the vCenter lacks,
+// matching existing ones by name, so it can be run again safely. It returns
+// the IDs on the vCenter, keyed by the exported IDs.
+func importTaxonomy(ctx context.Context, vsc *vsClient, t taxonomy) (map[string]string, error) {
+	existing, err := vsc.tagManager.GetCategories(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing categories: %w", err)
+	}
+
+	byName := make(map[string]tags.Category)
+	for _, c := range existing {
+		byName[c.Name] = c
+	}
+
+	ids := make(map[string]string)
+	for _, ec := range t.Categories {
+		if ec.Name == "" {
+			return nil, errors.New("category without a name")
+		}
+
+		catID, err := importCategory(ctx, vsc, ec, byName)
+		if err != nil {
+			return nil, err
+		}
+		if ec.ID != "" {
+			ids[ec.ID] = catID
+		}
+
+		ts, err := vsc.tagManager.GetTagsForCategory(ctx, catID)
+		if err != nil {
+			return nil, fmt.Errorf("listing tags of %s: %w", ec.Name, err)
+		}
+
+		tagIDs := make(map[string]string)
+		for _, tag := range ts {
+			tagIDs[tag.Name] = tag.ID
+		}
+
+		for _, et := range ec.Tags {
+			id, ok := tagIDs[et.Name]
+			if !ok {
+				fmt.Fprintf(os.Stderr, "Creating %s tag for %s\n", et.Name, ec.Name)
+				id, err = vsc.tagManager.CreateTag(ctx, &tags.Tag{
+					CategoryID:  catID,
+					Description: et.Description,
+					Name:        et.Name,
+				})
+				if err != nil {
+					return nil, fmt.Errorf("creating tag %s of %s: %w", et.Name, ec.Name, err)
+				}
+			}
+
+			if et.ID != "" {
+				ids[et.ID] = id
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// importCategory returns the ID of the category named like ec, creating it if
+// there is none. An existing category is left as it is.
+func importCategory(ctx context.Context, vsc *vsClient, ec exportedCategory, byName map[string]tags.Category) (string, error) {
+	if c, ok := byName[ec.Name]; ok {
+		if c.Cardinality != ec.Cardinality {
+			fmt.Fprintf(os.Stderr, "Category %s exists with cardinality %s rather than %s, keeping it\n", ec.Name, c.Cardinality, ec.Cardinality)
+		}
+
+		return c.ID, nil
+	}
+
+	cardinality := ec.Cardinality
+	if cardinality == "" {
+		cardinality = "SINGLE"
+	}
+
+	fmt.Fprintf(os.Stderr, "Creating category %s\n", ec.Name)
+	id, err := vsc.tagManager.CreateCategory(ctx, &tags.Category{
+		AssociableTypes: ec.AssociableTypes,
+		Cardinality:     cardinality,
+		Description:     ec.Description,
+		Name:            ec.Name,
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating category %s: %w", ec.Name, err)
+	}
+
+	return id, nil
+}
+
+func marshalTaxonomy(t taxonomy, format string) ([]byte, error) {
+	if format == "json" {
+		b, err := json.MarshalIndent(t, "", "  ")
+		return append(b, '\n'), err
+	}
+
+	return yaml.Marshal(t)
+}
+
+func unmarshalTaxonomy(b []byte, format string, t *taxonomy) error {
+	if format == "json" {
+		return json.Unmarshal(b, t)
+	}
+
+	return yaml.Unmarshal(b, t)
+}
+
+// writeOutput writes b to the file, or to stdout without one.
+func writeOutput(file string, b []byte) error {
+	if file == "" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// readInput reads the file, or stdin without one.
+func readInput(file string) ([]byte, error) {
+	if file == "" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	return ioutil.ReadFile(file)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEnvCredentials(t *testing.T) {
+	for _, name := range []string{"VEBA_TAG_GEN_SERVER", "VEBA_TAG_GEN_USER", "VEBA_TAG_GEN_PASS"} {
+		name := name
+		orig, set := os.LookupEnv(name)
+		os.Unsetenv(name)
+		t.Cleanup(func() {
+			if set {
+				os.Setenv(name, orig)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+	}
+
+	// An import from stdin can't be asked for credentials.
+	if _, err := envCredentials(true); err == nil || !strings.Contains(err.Error(), "VEBA_TAG_GEN_SERVER") {
+		t.Errorf("envCredentials(true) error = %v, want the variables named", err)
+	}
+
+	os.Setenv("VEBA_TAG_GEN_SERVER", "vc.example.com")
+	os.Setenv("VEBA_TAG_GEN_USER", "administrator")
+	os.Setenv("VEBA_TAG_GEN_PASS", "secret")
+
+	cfg, err := envCredentials(true)
+	if err != nil {
+		t.Fatalf("envCredentials(true) error = %v", err)
+	}
+	if want := (vcConfig{"vc.example.com", "administrator", "secret", true}); cfg != want {
+		t.Errorf("envCredentials(true) = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestPromptCredentials(t *testing.T) {
+	var out bytes.Buffer
+	cfg, err := promptCredentials(bufio.NewReader(strings.NewReader("vc.example.com\nadministrator\nsecret\n")), &out)
+	if err != nil {
+		t.Fatalf("promptCredentials() error = %v", err)
+	}
+
+	if want := (vcConfig{"vc.example.com", "administrator", "secret", true}); cfg != want {
+		t.Errorf("promptCredentials() = %+v, want %+v", cfg, want)
+	}
+
+	if !strings.Contains(out.String(), "vSphere password") {
+		t.Errorf("prompts = %q, want them written to out", out.String())
+	}
+}
+
+func TestFileFormat(t *testing.T) {
+	tests := []struct {
+		format, file, want string
+		wantErr            bool
+	}{
+		{"", "", "yaml", false},
+		{"", "tags.json", "json", false},
+		{"", "tags.JSON", "json", false},
+		{"", "tags.yml", "yaml", false},
+		{"json", "tags.yaml", "json", false},
+		{"xml", "", "", true},
+	}
+
+	for _, tc := range tests {
+		got, err := fileFormat(tc.format, tc.file)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("fileFormat(%q, %q) = %q, %v, want %q", tc.format, tc.file, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/vmware/govmomi/event"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// auditConfig is the optional [audit] section of vcconfig. Every mutation
+// made to vSphere is recorded in each configured sink.
+type auditConfig struct {
+	// File is a JSONL file records are appended to.
+	File string `toml:"file"`
+	// Syslog is "local" for the local syslog daemon, or udp://host:514 or
+	// tcp://host:514 for a remote one.
+	Syslog string `toml:"syslog"`
+	// Webhook is a URL each record is POSTed to as JSON.
+	Webhook string `toml:"webhook"`
+	// VCenterEvent posts an EventEx on the changed object.
+	VCenterEvent bool `toml:"vcenter_event"`
+	// Annotation appends a line to the changed VM's annotation.
+	Annotation bool `toml:"annotation"`
+}
+
+// Audit record results.
+const (
+	resultStarted = "started"
+	resultSuccess = "success"
+	resultFailure = "failure"
+	resultQueued  = "queued"
+	resultSkipped = "skipped"
+	resultRefused = "refused"
+	// resultRolledBack and resultRollbackFailed record failed changes whose
+	// steps were undone, or couldn't all be.
+	resultRolledBack     = "rolled_back"
+	resultRollbackFailed = "rollback_failed"
+)
+
+// auditRecord is one mutation made to vSphere, or deferred by the schedule.
+type auditRecord struct {
+	Time     time.Time                    `json:"time"`
+	Function string                       `json:"function"`
+	EventID  string                       `json:"event_id"`
+	Alarm    string                       `json:"alarm"`
+	VCenter  string                       `json:"vcenter"`
+	Object   types.ManagedObjectReference `json:"object"`
+	Action   string                       `json:"action"`
+	Before   interface{}                  `json:"before"`
+	After    interface{}                  `json:"after"`
+	Result   string                       `json:"result"`
+	Error    string                       `json:"error,omitempty"`
+	Reason   string                       `json:"reason,omitempty"`
+	TaskID   string                       `json:"task_id,omitempty"`
+}
+
+// withResult sets the record's result from the outcome of the mutation.
+func (r auditRecord) withResult(err error) auditRecord {
+	r.Result = resultSuccess
+	if err != nil {
+		r.Result = resultFailure
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+// summary is a one line description for vCenter events and annotations.
+func (r auditRecord) summary() string {
+	s := fmt.Sprintf("%s %s on %s: %s (event %s)", r.Function, r.Action, r.Object.Value, r.Result, r.EventID)
+	if r.Error != "" {
+		s += ": " + r.Error
+	}
+	if r.Reason != "" {
+		s += ": " + r.Reason
+	}
+
+	return s
+}
+
+// auditSink stores audit records.
+type auditSink interface {
+	write(ctx context.Context, b []byte) error
+}
+
+// auditor writes audit records to the configured sinks, and optionally to
+// vCenter itself.
+type auditor struct {
+	cfg   auditConfig
+	sinks map[string]auditSink
+	vc    *vim25.Client
+}
+
+func newAuditor(cfg auditConfig, vc *vim25.Client) *auditor {
+	a := auditor{
+		cfg:   cfg,
+		sinks: make(map[string]auditSink),
+		vc:    vc,
+	}
+
+	if cfg.File != "" {
+		a.sinks["file"] = fileSink(cfg.File)
+	}
+
+	if cfg.Syslog != "" {
+		a.sinks["syslog"] = syslogSink(cfg.Syslog)
+	}
+
+	if cfg.Webhook != "" {
+		a.sinks["webhook"] = webhookSink(cfg.Webhook)
+	}
+
+	return &a
+}
+
+// record writes rec everywhere it is configured to go. Failing to record is
+// logged, but does not fail the invocation: the change has already been made.
+func (a *auditor) record(ctx context.Context, rec auditRecord) {
+	rec.Time = time.Now().UTC()
+	rec.Function = functionName
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		logger(ctx).Error("encoding audit record", "error", err)
+		return
+	}
+
+	for name, s := range a.sinks {
+		if err := s.write(ctx, b); err != nil {
+			logger(ctx).Error("writing audit record", "sink", name, "error", err)
+		}
+	}
+
+	if a.cfg.VCenterEvent {
+		if err := a.postEvent(ctx, rec); err != nil {
+			logger(ctx).Error("posting audit event to vCenter", "error", err)
+		}
+	}
+
+	// Reconfiguring the VM while a task started on it is running would fail,
+	// so only finished changes are annotated.
+	var finished bool
+	switch rec.Result {
+	case resultSuccess, resultFailure, resultRolledBack, resultRollbackFailed:
+		finished = true
+	}
+	if a.cfg.Annotation && rec.Object.Type == "VirtualMachine" && finished {
+		if err := a.annotate(ctx, rec); err != nil {
+			logger(ctx).Error("annotating vm with audit record", "error", err)
+		}
+	}
+}
+
+// postEvent posts the record as an EventEx on the changed object, so it
+// shows in the object's events in the vSphere Client.
+func (a *auditor) postEvent(ctx context.Context, rec auditRecord) error {
+	evt := types.EventEx{
+		EventTypeId: "com.vmware.veba.remediation",
+		Severity:    eventSeverity(rec.Result),
+		Message:     rec.summary(),
+		ObjectId:    rec.Object.Value,
+		ObjectType:  rec.Object.Type,
+	}
+	evt.FullFormattedMessage = rec.summary()
+	evt.CreatedTime = rec.Time
+
+	if rec.Object.Type == "VirtualMachine" {
+		evt.Vm = &types.VmEventArgument{Vm: rec.Object}
+	}
+
+	return event.NewManager(a.vc).PostEvent(ctx, &evt)
+}
+
+// eventSeverity is the severity of the vCenter event for a result. A change
+// that could not be rolled back leaves the object part changed.
+func eventSeverity(result string) string {
+	switch result {
+	case resultFailure, resultRolledBack:
+		return "warning"
+	case resultRollbackFailed:
+		return "error"
+	}
+
+	return "info"
+}
+
+// annotate appends the record's summary to the VM's annotation.
+func (a *auditor) annotate(ctx context.Context, rec auditRecord) error {
+	vm := object.NewVirtualMachine(a.vc, rec.Object)
+
+	var moVM mo.VirtualMachine
+	if err := vm.Properties(ctx, rec.Object, []string{"config.annotation"}, &moVM); err != nil {
+		return err
+	}
+
+	note := fmt.Sprintf("%s %s", rec.Time.Format(time.RFC3339), rec.summary())
+	if moVM.Config != nil && moVM.Config.Annotation != "" {
+		note = moVM.Config.Annotation + "\n" + note
+	}
+
+	task, err := vm.Reconfigure(ctx, types.VirtualMachineConfigSpec{Annotation: note})
+	if err != nil {
+		return err
+	}
+
+	return task.Wait(ctx)
+}
+
+// fileSink appends records to a JSONL file.
+type fileSink string
+
+func (f fileSink) write(ctx context.Context, b []byte) error {
+	file, err := os.OpenFile(string(f), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// syslogSink sends records to syslog.
+type syslogSink string
+
+func (s syslogSink) write(ctx context.Context, b []byte) error {
+	var network, addr string
+
+	if s != "local" {
+		u, err := url.Parse(string(s))
+		if err != nil {
+			return fmt.Errorf("parsing syslog address: %w", err)
+		}
+		network, addr = u.Scheme, u.Host
+	}
+
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, functionName)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	return w.Info(string(b))
+}
+
+// webhookSink POSTs records to a URL.
+type webhookSink string
+
+func (w webhookSink) write(ctx context.Context, b []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, string(w), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s responded %s", redactString(string(w)), res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// automationConfig is the optional [automation] section of vcconfig. App
+// owners opt VMs out of remediation, or in to it, with a tag in Category or a
+// value of the custom attribute Attribute. The setting is taken from the VM,
+// or else inherited from its resource pools, cluster or folders, nearest
+// first.
+type automationConfig struct {
+	// Category is a tag category with "enabled" and "disabled" tags, e.g.
+	// "automation.scaling".
+	Category string `toml:"category"`
+	// Attribute is a custom attribute set to "enabled" or "disabled".
+	Attribute string `toml:"attribute"`
+	// OptIn only remediates VMs with automation enabled, rather than all VMs
+	// without it disabled.
+	OptIn bool `toml:"opt_in"`
+}
+
+func (c automationConfig) enabled() bool {
+	return c.Category != "" || c.Attribute != ""
+}
+
+// automationAllowed reports whether the VM may be remediated, and why.
+func (clt *vsClient) automationAllowed(ctx context.Context, cfg automationConfig, vm types.ManagedObjectReference) (allowed bool, reason string, err error) {
+	ctx, span := startSpan(ctx, "automation.Check", vmMoRef(vm.Value))
+	defer func() {
+		span.SetAttributes(attribute.Bool("automation.allowed", allowed))
+		endSpan(span, err)
+	}()
+
+	lineage, err := clt.lineage(ctx, vm)
+	if err != nil {
+		return false, "", fmt.Errorf("walking vm inventory: %w", err)
+	}
+
+	tagged, err := clt.automationTags(ctx, cfg.Category, lineage)
+	if err != nil {
+		return false, "", fmt.Errorf("reading automation tags: %w", err)
+	}
+
+	key, err := clt.customFieldKey(ctx, cfg.Attribute)
+	if err != nil {
+		return false, "", fmt.Errorf("reading automation attribute: %w", err)
+	}
+
+	for _, me := range lineage {
+		if v, ok := tagged[me.Self]; ok {
+			return v == "enabled", fmt.Sprintf("automation %s by tag on %s", v, me.Self.Value), nil
+		}
+
+		if v, ok := customValue(me, key); ok {
+			return v == "enabled", fmt.Sprintf("automation %s by attribute on %s", v, me.Self.Value), nil
+		}
+	}
+
+	if cfg.OptIn {
+		return false, "automation not enabled", nil
+	}
+
+	return true, "automation not disabled", nil
+}
+
+// lineage returns the VM followed by the objects it inherits settings from:
+// its resource pools up to the cluster, then its folders.
+func (clt *vsClient) lineage(ctx context.Context, vm types.ManagedObjectReference) ([]mo.ManagedEntity, error) {
+	pc := property.DefaultCollector(clt.govmomi.Client)
+
+	var moVM mo.VirtualMachine
+	err := timedRetrieve(func() error {
+		return pc.RetrieveOne(ctx, vm, []string{"parent", "resourcePool", "customValue"}, &moVM)
+	})
+	if err != nil {
+		return nil, err
+	}
+	moVM.Self = vm
+
+	lineage := []mo.ManagedEntity{moVM.ManagedEntity}
+
+	// walk follows parents while they are one of kinds.
+	walk := func(next *types.ManagedObjectReference, kinds ...string) error {
+		for next != nil && containsString(kinds, next.Type) {
+			var me mo.ManagedEntity
+			err := timedRetrieve(func() error {
+				return pc.RetrieveOne(ctx, *next, []string{"parent", "customValue"}, &me)
+			})
+			if err != nil {
+				return err
+			}
+			me.Self = *next
+			lineage = append(lineage, me)
+			next = me.Parent
+		}
+
+		return nil
+	}
+
+	// The root resource pool's parent is the cluster, or standalone host,
+	// whose parent is a host folder.
+	if err := walk(moVM.ResourcePool, "ResourcePool", "VirtualApp", "ClusterComputeResource", "ComputeResource"); err != nil {
+		return nil, err
+	}
+
+	// VM folders up to the datacenter.
+	if err := walk(moVM.Parent, "Folder"); err != nil {
+		return nil, err
+	}
+
+	return lineage, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// automationTags returns the name of the tag in category attached to each of
+// the objects, lower cased.
+func (clt *vsClient) automationTags(ctx context.Context, category string, lineage []mo.ManagedEntity) (map[types.ManagedObjectReference]string, error) {
+	tagged := make(map[types.ManagedObjectReference]string)
+	if category == "" {
+		return tagged, nil
+	}
+
+	if clt.tagMgr == nil {
+		return nil, errors.New("not logged into the rest api")
+	}
+
+	cat, err := clt.tagMgr.GetCategory(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]mo.Reference, 0, len(lineage))
+	for _, me := range lineage {
+		refs = append(refs, me.Self)
+	}
+
+	attached, err := clt.tagMgr.GetAttachedTagsOnObjects(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range attached {
+		for _, t := range a.Tags {
+			if t.CategoryID == cat.ID {
+				tagged[a.ObjectID.Reference()] = strings.ToLower(strings.TrimSpace(t.Name))
+			}
+		}
+	}
+
+	return tagged, nil
+}
+
+// customFieldKey returns the key of the named custom attribute, or -1 if
+// there is none.
+func (clt *vsClient) customFieldKey(ctx context.Context, name string) (int32, error) {
+	if name == "" {
+		return -1, nil
+	}
+
+	m, err := object.GetCustomFieldsManager(clt.govmomi.Client)
+	if err != nil {
+		return -1, err
+	}
+
+	key, err := m.FindKey(ctx, name)
+	if err == object.ErrKeyNameNotFound {
+		return -1, nil
+	}
+
+	return key, err
+}
+
+// customValue returns the entity's value of the custom attribute key, lower
+// cased.
+func customValue(me mo.ManagedEntity, key int32) (string, bool) {
+	for _, cv := range me.CustomValue {
+		if v, ok := cv.(*types.CustomFieldStringValue); ok && v.Key == key && v.Value != "" {
+			return strings.ToLower(strings.TrimSpace(v.Value)), true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,231 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// capacityConfig is the optional [capacity] section of vcconfig. Increments
+// are checked against the headroom of the VM's host, cluster and resource
+// pool, so that scaling one VM doesn't starve the others.
+type capacityConfig struct {
+	// CPUOvercommit is the most vCPUs of powered on VMs per physical core,
+	// e.g. 4.0 (TOML needs the decimal point). Zero doesn't check CPU.
+	CPUOvercommit float64 `toml:"cpu_overcommit"`
+	// MemoryOvercommit is the most memory configured on powered on VMs per
+	// MB of physical memory, e.g. 1.5. Zero doesn't check memory.
+	MemoryOvercommit float64 `toml:"memory_overcommit"`
+	// Cap scales by as much as fits when the whole increment doesn't, rather
+	// than refusing it.
+	Cap bool `toml:"cap"`
+}
+
+func (c capacityConfig) enabled() bool {
+	return c.CPUOvercommit > 0 || c.MemoryOvercommit > 0
+}
+
+func (c capacityConfig) validate() error {
+	if c.CPUOvercommit < 0 || c.MemoryOvercommit < 0 {
+		return errors.New("capacity overcommit ratios must not be negative")
+	}
+
+	return nil
+}
+
+// unlimited is the capacity of a scope that doesn't limit it, like a
+// resource pool limit of -1.
+const unlimited = -1
+
+// capacityScope is the physical capacity of a host, cluster or resource
+// pool, and what the other powered on VMs in it are configured with. A
+// capacity of zero leaves no room, only unlimited doesn't limit it.
+type capacityScope struct {
+	name           string
+	cpus, memoryMB float64
+	usedCPUs       float64
+	usedMemoryMB   float64
+}
+
+// maxValue returns the largest value of the category the VM can be scaled to
+// within the scopes, and why. It is -1 when nothing limits the value.
+func (c capacityConfig) maxValue(category string, scopes []capacityScope) (int, string) {
+	max, reason := -1, ""
+
+	for _, s := range scopes {
+		var (
+			ratio, capacity, used float64
+			unit                  string
+		)
+
+		switch category {
+		case "config.hardware.numCPU":
+			ratio, capacity, used, unit = c.CPUOvercommit, s.cpus, s.usedCPUs, "vCPUs"
+		case "config.hardware.memoryMB":
+			ratio, capacity, used, unit = c.MemoryOvercommit, s.memoryMB, s.usedMemoryMB, "MB"
+		}
+
+		if ratio <= 0 || capacity == unlimited {
+			continue
+		}
+
+		room := int(math.Max(0, math.Floor(capacity*ratio-used)))
+		if max < 0 || room < max {
+			max = room
+			reason = fmt.Sprintf("%s allows at most %d %s at %g:1 overcommit", s.name, room, unit, ratio)
+		}
+	}
+
+	return max, reason
+}
+
+// capTag returns the name of the largest tag whose value is above current
+// and at most max, or "" if there is none.
+func capTag(ts []tags.Tag, current, max int) string {
+	name, best := "", current
+	for _, t := range ts {
+		v, err := strconv.Atoi(t.Name)
+		if err == nil && v > best && v <= max {
+			name, best = t.Name, v
+		}
+	}
+
+	return name
+}
+
+// capacityScopes returns the capacity of the VM's host, its cluster and its
+// resource pool, if the pool has limits.
+func (clt *vsClient) capacityScopes(ctx context.Context, moVM mo.VirtualMachine) (scopes []capacityScope, err error) {
+	ctx, span := startSpan(ctx, "capacity.Check", vmMoRef(moVM.Self.Value))
+	defer func() { endSpan(span, err) }()
+
+	if moVM.Runtime.Host == nil {
+		return nil, nil
+	}
+
+	pc := property.DefaultCollector(clt.govmomi.Client)
+
+	var host mo.HostSystem
+	err = timedRetrieve(func() error {
+		return pc.RetrieveOne(ctx, *moVM.Runtime.Host, []string{"summary.hardware", "vm", "parent"}, &host)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("retrieving host: %w", err)
+	}
+
+	hw := host.Summary.Hardware
+	if hw == nil {
+		return nil, errors.New("host hardware summary is empty")
+	}
+
+	hostScope := capacityScope{
+		name:     "host " + moVM.Runtime.Host.Value,
+		cpus:     float64(hw.NumCpuCores),
+		memoryMB: float64(hw.MemorySize) / (1 << 20),
+	}
+	if err := clt.addUsage(ctx, &hostScope, moVM.Self, host.Vm); err != nil {
+		return nil, err
+	}
+	scopes = append(scopes, hostScope)
+
+	if host.Parent != nil && host.Parent.Type == "ClusterComputeResource" {
+		var cluster mo.ClusterComputeResource
+		err := timedRetrieve(func() error {
+			return pc.RetrieveOne(ctx, *host.Parent, []string{"summary", "host"}, &cluster)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("retrieving cluster: %w", err)
+		}
+
+		var hosts []mo.HostSystem
+		err = timedRetrieve(func() error {
+			return pc.Retrieve(ctx, cluster.Host, []string{"vm"}, &hosts)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("retrieving cluster hosts: %w", err)
+		}
+
+		var vms []types.ManagedObjectReference
+		for _, h := range hosts {
+			vms = append(vms, h.Vm...)
+		}
+
+		summary := cluster.Summary.GetComputeResourceSummary()
+		clusterScope := capacityScope{
+			name:     "cluster " + host.Parent.Value,
+			cpus:     float64(summary.NumCpuCores),
+			memoryMB: float64(summary.TotalMemory) / (1 << 20),
+		}
+		if err := clt.addUsage(ctx, &clusterScope, moVM.Self, vms); err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, clusterScope)
+	}
+
+	if moVM.ResourcePool != nil {
+		var pool mo.ResourcePool
+		err := timedRetrieve(func() error {
+			return pc.RetrieveOne(ctx, *moVM.ResourcePool, []string{"config", "vm"}, &pool)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("retrieving resource pool: %w", err)
+		}
+
+		poolScope := capacityScope{
+			name:     "resource pool " + moVM.ResourcePool.Value,
+			cpus:     unlimited,
+			memoryMB: unlimited,
+		}
+
+		// A pool limit of -1, or none, is unlimited, but a limit of 0 is
+		// real. Pool CPU limits are in MHz, counted in cores of the VM's host.
+		if l := pool.Config.CpuAllocation.Limit; l != nil && *l >= 0 && hw.CpuMhz > 0 {
+			poolScope.cpus = float64(*l) / float64(hw.CpuMhz)
+		}
+
+		if l := pool.Config.MemoryAllocation.Limit; l != nil && *l >= 0 {
+			poolScope.memoryMB = float64(*l)
+		}
+
+		if poolScope.cpus != unlimited || poolScope.memoryMB != unlimited {
+			if err := clt.addUsage(ctx, &poolScope, moVM.Self, pool.Vm); err != nil {
+				return nil, err
+			}
+			scopes = append(scopes, poolScope)
+		}
+	}
+
+	return scopes, nil
+}
+
+// addUsage adds the vCPUs and memory of the powered on VMs, other than self,
+// to the scope's usage.
+func (clt *vsClient) addUsage(ctx context.Context, s *capacityScope, self types.ManagedObjectReference, vms []types.ManagedObjectReference) error {
+	var moVMs []mo.VirtualMachine
+
+	pc := property.DefaultCollector(clt.govmomi.Client)
+	err := timedRetrieve(func() error {
+		return pc.Retrieve(ctx, vms, []string{"config.hardware.numCPU", "config.hardware.memoryMB", "runtime.powerState"}, &moVMs)
+	})
+	if err != nil {
+		return fmt.Errorf("retrieving vms of %s: %w", s.name, err)
+	}
+
+	for _, vm := range moVMs {
+		if vm.Self == self || vm.Config == nil || vm.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+			continue
+		}
+
+		s.usedCPUs += float64(vm.Config.Hardware.NumCPU)
+		s.usedMemoryMB += float64(vm.Config.Hardware.MemoryMB)
+	}
+
+	return nil
+}
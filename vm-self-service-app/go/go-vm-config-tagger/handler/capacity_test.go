@@ -0,0 +1,59 @@
+package function
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vapi/tags"
+)
+
+func TestCapacityMaxValue(t *testing.T) {
+	host := capacityScope{name: "host host-1", cpus: 8, memoryMB: 65536, usedCPUs: 28, usedMemoryMB: 60000}
+	cluster := capacityScope{name: "cluster c-1", cpus: 32, memoryMB: 262144, usedCPUs: 120, usedMemoryMB: 100000}
+	pool := capacityScope{name: "resource pool p-1", cpus: unlimited, memoryMB: 16384, usedMemoryMB: 14000}
+	emptyPool := capacityScope{name: "resource pool p-2", cpus: unlimited, memoryMB: 0}
+
+	tests := []struct {
+		name     string
+		cfg      capacityConfig
+		category string
+		scopes   []capacityScope
+		want     int
+	}{
+		{"cpu host", capacityConfig{CPUOvercommit: 4}, "config.hardware.numCPU", []capacityScope{host}, 4},
+		{"cpu cluster tighter", capacityConfig{CPUOvercommit: 4}, "config.hardware.numCPU", []capacityScope{host, cluster}, 4},
+		{"cpu full", capacityConfig{CPUOvercommit: 3}, "config.hardware.numCPU", []capacityScope{host}, 0},
+		{"cpu not checked", capacityConfig{MemoryOvercommit: 1}, "config.hardware.numCPU", []capacityScope{host}, -1},
+		{"memory host", capacityConfig{MemoryOvercommit: 1}, "config.hardware.memoryMB", []capacityScope{host, cluster}, 5536},
+		{"memory pool limit", capacityConfig{MemoryOvercommit: 1}, "config.hardware.memoryMB", []capacityScope{host, cluster, pool}, 2384},
+		{"pool without cpu limit", capacityConfig{CPUOvercommit: 1}, "config.hardware.numCPU", []capacityScope{pool}, -1},
+		{"pool with zero memory limit", capacityConfig{MemoryOvercommit: 1}, "config.hardware.memoryMB", []capacityScope{host, emptyPool}, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, reason := tc.cfg.maxValue(tc.category, tc.scopes); got != tc.want {
+				t.Errorf("maxValue = %d (%s), want %d", got, reason, tc.want)
+			}
+		})
+	}
+}
+
+func TestCapTag(t *testing.T) {
+	ts := []tags.Tag{{Name: "1024"}, {Name: "2048"}, {Name: "4096"}, {Name: "8192"}, {Name: "other"}}
+
+	tests := []struct {
+		current, max int
+		want         string
+	}{
+		{1024, 8192, "8192"},
+		{1024, 5000, "4096"},
+		{1024, 2047, ""},
+		{4096, 4096, ""},
+	}
+
+	for _, tc := range tests {
+		if got := capTag(ts, tc.current, tc.max); got != tc.want {
+			t.Errorf("capTag(%d, %d) = %q, want %q", tc.current, tc.max, got, tc.want)
+		}
+	}
+}
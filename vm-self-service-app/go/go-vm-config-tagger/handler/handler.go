@@ -1,16 +1,18 @@
 package function
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"math"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	handler "github.com/openfaas/templates-sdk/go-http"
 	"github.com/pelletier/go-toml"
@@ -19,10 +21,22 @@ import (
 	"github.com/vmware/govmomi/vapi/rest"
 	"github.com/vmware/govmomi/vapi/tags"
 	"github.com/vmware/govmomi/vim25/mo"
-	"github.com/vmware/govmomi/vim25/types"d
+	"github.com/vmware/govmomi/vim25/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const secretPath = "/var/openfaas/secrets/vcconfig"
+// secretMountPath is where OpenFaaS mounts secrets, unless overridden by the
+// secret_mount_path environment variable (e.g. when running locally).
+const secretMountPath = "/var/openfaas/secrets"
+
+// functionName names the function in its logs, traces, audit records and
+// notifications, and tracerName its spans.
+const (
+	functionName = "vm-config-tagger-fn"
+	tracerName   = "github.com/pksrc/vebafn/vm-self-service-app/go/go-vm-config-tagger"
+)
 
 // vcConfig represents the toml vcconfig file
 type vcConfig struct {
@@ -32,6 +46,14 @@ type vcConfig struct {
 		Password string
 		Insecure bool
 	}
+	Audit      auditConfig
+	Approval   approvalConfig
+	Schedule   scheduleConfig
+	Automation automationConfig
+	Limits     limitsConfig
+	Capacity   capacityConfig
+	Power      powerConfig
+	Mapping    mappingConfig
 }
 
 // vsClient stores vSphere connection information.
@@ -43,22 +65,61 @@ type vsClient struct {
 
 // cloudEvent stores incoming event data.
 type cloudEvent struct {
+	ID   string
 	Data types.AlarmStatusChangedEvent
 }
 
 // Handle a function invocation
 func Handle(req handler.Request) (handler.Response, error) {
-	ctx := context.Background()
+	q, _ := url.ParseQuery(req.QueryString)
+	if q.Get("approval") != "" {
+		return handleApproval(req, q)
+	}
+
+	if req.Method == http.MethodGet {
+		return metricsResponse()
+	}
+
+	if len(bytes.TrimSpace(req.Body)) == 0 {
+		return runQueue(req)
+	}
+	defer pushMetrics()
+
+	initTracing()
+	ctx, span := startSpan(traceContext(req.Header), "Handle")
+	defer flushTraces(ctx)
+	defer span.End()
+	ctx = withLogger(ctx, baseLogger)
 
 	cloudEvt, err := parseCloudEvent(req.Body)
 	if err != nil {
-		return errRespondAndLog(fmt.Errorf("parsing cloud event data: %w", err))
+		return failed(ctx, "", actionNone, fmt.Errorf("parsing cloud event data: %w", err))
 	}
 
-	// Determine if data AlarmStatusChangedEvent is correct.
-	if !isCpuOrMemoryAlarm(cloudEvt) {
-		message := "Alert not for CPU/Memory in red, nothing to do."
-		log.Println(message)
+	alarm := cloudEvt.Data.Alarm.Name
+	span.SetAttributes(cloudEventID(cloudEvt.ID), vmMoRef(cloudEvt.Data.Vm.Vm.Value))
+	ctx = withLogger(ctx, logger(ctx).With("event_id", cloudEvt.ID, "vm", cloudEvt.Data.Vm.Vm.Value))
+	logger(ctx).Debug("event received", "alarm", alarm, "from", cloudEvt.Data.From, "to", cloudEvt.Data.To)
+
+	// Load config every time, to ensure the most updated version is used.
+	cfg, err := loadTomlCfg(secretPath("vcconfig"))
+	if err != nil {
+		countEvent(alarm, actionNone, outcomeReceived)
+		return failed(ctx, alarm, actionNone, fmt.Errorf("loading of vcconfig: %w", err))
+	}
+	ctx = withLogger(ctx, logger(ctx).With("vcenter", cfg.VCenter.Server))
+
+	rules := matchingRules(cfg.Mapping.rules(), alarm, cloudEvt.Data.To)
+	action := actionNone
+	if len(rules) > 0 {
+		action = rules[0].action()
+	}
+	countEvent(alarm, action, outcomeReceived)
+
+	if len(rules) == 0 {
+		message := fmt.Sprintf("No rule for %s turning %s, nothing to do.", alarm, cloudEvt.Data.To)
+		logger(ctx).Info(message, "alarm", alarm)
+		countEvent(alarm, action, outcomeIgnored)
 
 		return handler.Response{
 			Body:       []byte(message),
@@ -66,52 +127,136 @@ func Handle(req handler.Request) (handler.Response, error) {
 		}, nil
 	}
 
-	// Load config every time, to ensure the most updated version is used.
-	cfg, err := loadTomlCfg(secretPath)
+	start := time.Now()
+	loginCtx, loginSpan := startSpan(ctx, "vcenter.login")
+	vsClient, err := newClient(loginCtx, cfg)
+	endSpan(loginSpan, err)
 	if err != nil {
-		return errRespondAndLog(fmt.Errorf("loading of vcconfig: %w", err))
-	}
-
-	vsClient, err := newClient(ctx, cfg)
-	if err != nil {
-		return errRespondAndLog(fmt.Errorf("connecting to vSphere: %w", err))
+		return failed(ctx, alarm, action, fmt.Errorf("connecting to vSphere: %w", err))
 	}
+	observeSince(loginDuration, start)
 
 	// Retrieve the Managed Object Reference from the event.
 	vmMOR, err := eventVmMoRef(cloudEvt)
 	if err != nil {
-		return errRespondAndLog(fmt.Errorf("retrieving VM managed reference object: %w", err))
+		return failed(ctx, alarm, action, fmt.Errorf("retrieving VM managed reference object: %w", err))
+	}
+
+	if cfg.Automation.enabled() {
+		allowed, reason, err := vsClient.automationAllowed(ctx, cfg.Automation, vmMOR)
+		if err != nil {
+			return failed(ctx, alarm, action, fmt.Errorf("checking automation setting: %w", err))
+		}
+
+		if !allowed {
+			message := fmt.Sprintf("Not remediating %s, %s.", vmMOR.Value, reason)
+			logger(ctx).Info(message, "alarm", alarm, "action", action)
+			countEvent(alarm, action, outcomeOptedOut)
+
+			return handler.Response{
+				Body:       []byte(message),
+				StatusCode: http.StatusOK,
+			}, nil
+		}
 	}
 
 	// moVM contains the memory and CPU config values.
+	start = time.Now()
 	moVM, err := vsClient.moVirtualMachine(ctx, vmMOR)
 	if err != nil {
-		return errRespondAndLog(fmt.Errorf("getting vm configs: %w", err))
+		return failed(ctx, alarm, action, fmt.Errorf("getting vm configs: %w", err))
 	}
+	observeSince(retrieveDuration, start)
 
-	catID, tagID, err := vsClient.findIncrementedTag(ctx, cloudEvt, moVM)
-	if err != nil {
-		return errRespondAndLog(fmt.Errorf("finding incremented tag: %w", err))
+	// The history is kept for the longest window of any rule, so recording
+	// this alarm doesn't forget the firings other alarms' rules count.
+	var fired []time.Time
+	if longestRepeatWindow(rules) > 0 {
+		fired, err = recordFiring(cfg.Mapping.Store, vmMOR.Value, alarm, now(), longestRepeatWindow(cfg.Mapping.rules()))
+		if err != nil {
+			return failed(ctx, alarm, action, err)
+		}
 	}
 
-	message := "No tag to attach."
+	// The first rule with a change to make is applied.
+	var (
+		chosen               rule
+		catID, tagID, capped string
+	)
+	for _, r := range rules {
+		if !r.repeated(fired, now()) {
+			continue
+		}
 
-	if tagID != "" {
-		// Detach tags in the same catID, but different tagID.
-		err = vsClient.detachTags(ctx, catID, tagID, vmMOR)
+		catID, tagID, capped, err = vsClient.findIncrementedTag(ctx, r, moVM, cfg.Capacity)
 		if err != nil {
-			return errRespondAndLog(fmt.Errorf("detaching old tag(s): %w", err))
+			return failed(ctx, alarm, r.action(), fmt.Errorf("finding incremented tag: %w", err))
 		}
 
-		err = vsClient.tagMgr.AttachTag(ctx, tagID, vmMOR)
-		if err != nil {
-			return errRespondAndLog(fmt.Errorf("tagging managed reference object: %w", err))
+		if tagID != "" || capped != "" {
+			chosen, action = r, r.action()
+			break
+		}
+	}
+
+	message := "No tag to attach."
+	outcome := outcomeIgnored
+
+	timing, why := cfg.Power.changeTiming(moVM, chosen.Category)
+
+	switch {
+	case tagID == "" && capped != "":
+		message = fmt.Sprintf("Not scaling %s, %s.\n", vmMOR.Value, capped)
+		outcome = outcomeNoCapacity
+	case tagID != "" && timing == timingSkip:
+		message = fmt.Sprintf("Not scaling %s, %s.\n", vmMOR.Value, why)
+		outcome = outcomeNoHotAdd
+	case tagID != "":
+		change := tagChange{
+			EventID:  cloudEvt.ID,
+			Alarm:    alarm,
+			Action:   action,
+			VM:       vmMOR,
+			CatID:    catID,
+			TagID:    tagID,
+			NumCPU:   moVM.Config.Hardware.NumCPU,
+			MemoryMB: moVM.Config.Hardware.MemoryMB,
+			Timing:   timing,
+		}
+
+		if timing == timingPowerCycle && cfg.Power.PendingCategory != "" {
+			change.PendingTagID, err = vsClient.pendingTag(ctx, cfg.Power.PendingCategory)
+			if err != nil {
+				return failed(ctx, alarm, action, fmt.Errorf("finding pending tag: %w", err))
+			}
+		}
+
+		if cfg.Approval.Enabled {
+			p, err := requestApproval(ctx, cfg.Approval, change)
+			if err != nil {
+				return failed(ctx, alarm, action, fmt.Errorf("requesting approval: %w", err))
+			}
+
+			message = fmt.Sprintf("Approval %s requested to attach tag %v.\n", p.ID, tagID)
+			outcome = outcomePending
+		} else {
+			message, outcome, err = carryOut(ctx, cfg, vsClient, change)
+			if err != nil {
+				return failed(ctx, alarm, action, err)
+			}
+		}
+
+		if timing == timingPowerCycle && (outcome == outcomeActed || outcome == outcomePending || outcome == outcomeQueued) {
+			message += fmt.Sprintf("The change is made at the next power cycle, %s.\n", why)
 		}
 
-		message = fmt.Sprintf("Attached tag %v.\n", tagID)
+		if capped != "" {
+			message = fmt.Sprintf("Increment capped, %s. %s", capped, message)
+		}
 	}
 
-	log.Println(message)
+	logger(ctx).Info(strings.TrimSpace(message), "alarm", alarm, "action", action)
+	countEvent(alarm, action, outcome)
 
 	return handler.Response{
 		Body:       []byte(message),
@@ -119,10 +264,20 @@ func Handle(req handler.Request) (handler.Response, error) {
 	}, nil
 }
 
-func errRespondAndLog(err error) (handler.Response, error) {
-	if debug() {
-		log.Println(err.Error())
-	}
+// failed counts a failed event and marks the invocation's span as failed
+// before responding with the error.
+func failed(ctx context.Context, alarm, action string, err error) (handler.Response, error) {
+	countEvent(alarm, action, outcomeFailed)
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	return errRespondAndLog(ctx, err)
+}
+
+func errRespondAndLog(ctx context.Context, err error) (handler.Response, error) {
+	logger(ctx).Error("invocation failed", "error", err)
 
 	return handler.Response{
 		Body:       []byte(err.Error()),
@@ -130,15 +285,14 @@ func errRespondAndLog(err error) (handler.Response, error) {
 	}, err
 }
 
-// Debug determines verbose logging
-func debug() bool {
-	verbose := os.Getenv("write_debug")
-
-	if verbose == "true" {
-		return true
+// secretPath returns the path of the named secret.
+func secretPath(name string) string {
+	dir := os.Getenv("secret_mount_path")
+	if dir == "" {
+		dir = secretMountPath
 	}
 
-	return false
+	return filepath.Join(dir, name)
 }
 
 func parseCloudEvent(req []byte) (cloudEvent, error) {
@@ -168,16 +322,6 @@ func isValidEvent(event cloudEvent) error {
 	return nil
 }
 
-func isCpuOrMemoryAlarm(event cloudEvent) bool {
-	alarm := false
-
-	if event.Data.To == "red" && (event.Data.Alarm.Name == "VM Memory Usage" || event.Data.Alarm.Name == "VM CPU Usage") {
-		alarm = true
-	}
-
-	return alarm
-}
-
 func loadTomlCfg(path string) (*vcConfig, error) {
 	var cfg vcConfig
 
@@ -190,6 +334,7 @@ func loadTomlCfg(path string) (*vcConfig, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unmarshalling vcconfig.toml: %w", err)
 	}
+	redactSecret(cfg.VCenter.Password)
 
 	err = validateConfig(cfg)
 	if err != nil {
@@ -214,6 +359,34 @@ func validateConfig(cfg vcConfig) error {
 		}
 	}
 
+	if cfg.Approval.Enabled {
+		if err := cfg.Approval.validate(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Schedule.enabled() {
+		if err := cfg.Schedule.validate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := cfg.Limits.window(); err != nil {
+		return err
+	}
+
+	if err := cfg.Capacity.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Power.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Mapping.validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -267,7 +440,10 @@ func (c *vsClient) moVirtualMachine(ctx context.Context, mor types.ManagedObject
 
 	pc := property.DefaultCollector(c.govmomi.Client)
 
-	err := pc.Retrieve(ctx, []types.ManagedObjectReference{mor}, []string{}, &moVM)
+	ctx, span := startSpan(ctx, "PropertyCollector.Retrieve", vmMoRef(mor.Value))
+	// A nil property list retrieves all properties, an empty one retrieves none.
+	err := pc.Retrieve(ctx, []types.ManagedObjectReference{mor}, nil, &moVM)
+	endSpan(span, err)
 	if err != nil {
 		return mo.VirtualMachine{}, err
 	}
@@ -283,74 +459,79 @@ func (c *vsClient) moVirtualMachine(ctx context.Context, mor types.ManagedObject
 	return moVM, nil
 }
 
-// findIncrementedTag finds the current config value for the type, and will select
-// the tag that is an increment above it (but below the limits).
-func (clt *vsClient) findIncrementedTag(ctx context.Context, ce cloudEvent, moVM mo.VirtualMachine) (string, string, error) {
-	catName := catName(ce.Data.Alarm.Name)
-	tagName := ""
-	// get the expected name of the tag (incremented value)
+// findIncrementedTag finds the tag the rule sets on the VM: a preset, or the
+// increment above the VM's current size (within the hardware limits). When
+// the increment doesn't fit the capacity, capped says why, and the tag is
+// either a smaller increment or none.
+func (clt *vsClient) findIncrementedTag(ctx context.Context, r rule, moVM mo.VirtualMachine, capacity capacityConfig) (catID, tagID, capped string, err error) {
+	catName := r.Category
 
-	switch catName {
-	case "config.hardware.numCPU":
-		// CPU tags are easy. Just increment it up to the max of 4.
-		tagName = incCpuVal(int(moVM.Config.Hardware.NumCPU))
-	case "config.hardware.memoryMB":
-		// Mem tags are a bit tricky. Gotta find the exponent to the 2 base.
-		// Then, increment the exponent up to the max of 23 (8 gb RAM).
-		tagName = incMemVal(float64(moVM.Config.Hardware.MemoryMB))
+	tagCtx, span := startSpan(ctx, "tags.GetTagsForCategory", attribute.String("vsphere.category", catName))
+	tagList, err := clt.tagMgr.GetTagsForCategory(tagCtx, catName)
+	endSpan(span, err)
+	if err != nil {
+		return "", "", "", fmt.Errorf("listing tags of category %s: %w", catName, err)
 	}
 
-	tagList, err := clt.tagMgr.GetTagsForCategory(ctx, catName)
-	if err != nil {
-		return "", "", err
+	tagNames := make([]string, 0, len(tagList))
+	for _, t := range tagList {
+		tagNames = append(tagNames, t.Name)
 	}
 
-	catID, tagID := findCatAndTagIDs(tagList, tagName)
+	if r.Value != "" && !containsString(tagNames, r.Value) {
+		return "", "", "", fmt.Errorf("mapping rule value %q is not a tag of category %s", r.Value, catName)
+	}
 
-	// return the tag ID given the name.
-	return catID, tagID, nil
-}
+	scaling := catName == "config.hardware.numCPU" || catName == "config.hardware.memoryMB"
 
-// catName returns the category name based on alarm name.
-func catName(alarmName string) string {
-	switch alarmName {
-	case "VM CPU Usage":
-		return "config.hardware.numCPU"
-	case "VM Memory Usage":
-		return "config.hardware.memoryMB"
+	var guest hardwareMax
+	if scaling && r.Value == "" {
+		if guest, err = clt.guestLimits(ctx, moVM); err != nil {
+			return "", "", "", fmt.Errorf("getting guest os limits: %w", err)
+		}
 	}
 
-	return ""
-}
+	tagName := r.target(moVM, tagNames, guest)
 
-func incCpuVal(numCPU int) string {
-	newNum := 4
-	numCPU++
-	if numCPU < newNum {
-		newNum = numCPU
-	}
+	logger(ctx).Debug("incremented config value", "category", catName,
+		"numCPU", moVM.Config.Hardware.NumCPU, "memoryMB", moVM.Config.Hardware.MemoryMB, "tag", tagName)
 
-	log.Printf("\ncurrent CPU: %v, new CPU: %v\n", numCPU, newNum)
-	return strconv.Itoa(newNum)
-}
+	if capacity.enabled() && scaling && tagName != "" {
+		scopes, err := clt.capacityScopes(ctx, moVM)
+		if err != nil {
+			return "", "", "", fmt.Errorf("checking capacity: %w", err)
+		}
 
-// Use MB values, not bytes.
-func incMemVal(mem float64) string {
-	// 2^13 = 8192. 8GB is max RAM.
-	maxExp := 13
-	newMem := 1 << maxExp
+		current := int(moVM.Config.Hardware.NumCPU)
+		if catName == "config.hardware.memoryMB" {
+			current = int(moVM.Config.Hardware.MemoryMB)
+		}
 
-	exp := int(math.Round(math.Log10(mem) / math.Log10(2)))
-	exp++
+		want, _ := strconv.Atoi(tagName)
+		if max, reason := capacity.maxValue(catName, scopes); max >= 0 && want > max {
+			capped = reason
+			tagName = ""
+			if capacity.Cap {
+				tagName = capTag(tagList, current, max)
+			}
 
-	if exp < maxExp {
-		newMem = 1 << exp
+			logger(ctx).Info("increment over capacity", "category", catName, "wanted", want, "tag", tagName, "reason", reason)
+		}
 	}
 
-	log.Printf("\ncurrent memory: %v, new memory: %v\n", mem, newMem)
-	return strconv.Itoa(newMem)
+	catID, tagID = findCatAndTagIDs(tagList, tagName)
+
+	// return the tag ID given the name.
+	return catID, tagID, capped, nil
 }
 
+// Actions the tagger takes, used to label metrics.
+const (
+	actionNone        = "none"
+	actionScaleCPU    = "scale_cpu"
+	actionScaleMemory = "scale_memory"
+)
+
 func findCatAndTagIDs(ts []tags.Tag, tn string) (string, string) {
 	for _, t := range ts {
 		if t.Name == tn {
@@ -361,20 +542,117 @@ func findCatAndTagIDs(ts []tags.Tag, tn string) (string, string) {
 	return "", ""
 }
 
-func (clt *vsClient) detachTags(ctx context.Context, catID, tagID string, mor types.ManagedObjectReference) error {
-	tagList, err := clt.tagMgr.GetAttachedTags(ctx, mor)
+// tagChange is the tag change made for an alarm, either directly or once
+// approved.
+type tagChange struct {
+	EventID  string                       `json:"event_id"`
+	Alarm    string                       `json:"alarm"`
+	Action   string                       `json:"action"`
+	VM       types.ManagedObjectReference `json:"vm"`
+	CatID    string                       `json:"category_id"`
+	TagID    string                       `json:"tag_id"`
+	NumCPU   int32                        `json:"numCPU"`
+	MemoryMB int32                        `json:"memoryMB"`
+	// Timing is when the tagged change can be made to the VM, and
+	// PendingTagID the tag marking it as waiting for a power cycle.
+	Timing       string `json:"timing,omitempty"`
+	PendingTagID string `json:"pending_tag_id,omitempty"`
+}
+
+// apply attaches the tag, detaching the others in its category, and records
+// the change in the audit trail. A change that fails part way is rolled back,
+// so the VM keeps its previous tags. A change refused by the limits returns a
+// *limitError.
+func (c tagChange) apply(ctx context.Context, cfg *vcConfig, clt *vsClient) error {
+	release, err := clt.acquireLimits(ctx, cfg.Limits, c.VM)
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	// Loop through the tags and detach the ones that are in catID but are not tagID.
-	for _, t := range tagList {
-		if t.CategoryID == catID && t.ID != tagID {
-			if err := clt.tagMgr.DetachTag(ctx, t.ID, mor); err != nil {
-				return err
-			}
-		}
+	start := time.Now()
+	audit := newAuditor(cfg.Audit, clt.govmomi.Client)
+	rec := c.auditRecord(cfg.VCenter.Server)
+	tx := &transaction{}
+
+	// Swap the tags in catID for tagID.
+	before, err := clt.swapTags(ctx, tx, c.CatID, c.TagID, c.PendingTagID, c.VM)
+	rec.Before = tagState{
+		NumCPU:   c.NumCPU,
+		MemoryMB: c.MemoryMB,
+		TagIDs:   before,
+	}
+	if err != nil {
+		return c.rollBack(ctx, clt, tx, audit, rec, err)
 	}
 
+	audit.record(ctx, rec.withResult(nil))
+	observeSince(taskDuration.WithLabelValues(c.Action), start)
+
 	return nil
 }
+
+// rollBack undoes the steps of a change that failed with cause, and records
+// the VM's tags in the category afterwards as the change's final state.
+func (c tagChange) rollBack(ctx context.Context, clt *vsClient, tx *transaction, audit *auditor, rec auditRecord, cause error) error {
+	rbErr := tx.rollback(ctx)
+
+	rec = rec.withResult(cause)
+	rec.Result = resultRolledBack
+	if rbErr != nil {
+		rec.Result = resultRollbackFailed
+		rec.Error += "; " + rbErr.Error()
+	}
+	rollbacks.WithLabelValues(c.Action, rec.Result).Inc()
+
+	after, _, err := clt.categoryTags(ctx, c.CatID, c.VM)
+	if err != nil {
+		logger(ctx).Warn("reading tags after rollback", "error", err)
+		rec.After = nil
+	} else {
+		rec.After = tagState{NumCPU: c.NumCPU, MemoryMB: c.MemoryMB, TagIDs: after}
+	}
+	audit.record(ctx, rec)
+
+	state := "unknown"
+	if err == nil {
+		state = fmt.Sprintf("%v", after)
+	}
+
+	if rbErr != nil {
+		return fmt.Errorf("%w; rolling back failed, %v; tags of the vm in the category: %s", cause, rbErr, state)
+	}
+
+	return fmt.Errorf("%w; rolled back, tags of the vm in the category: %s", cause, state)
+}
+
+// auditRecord returns the audit record of the change, before it is made.
+func (c tagChange) auditRecord(vcenter string) auditRecord {
+	return auditRecord{
+		EventID: c.EventID,
+		Alarm:   c.Alarm,
+		VCenter: vcenter,
+		Object:  c.VM,
+		Action:  c.Action,
+		Before: tagState{
+			NumCPU:   c.NumCPU,
+			MemoryMB: c.MemoryMB,
+		},
+		After: tagState{
+			NumCPU:   c.NumCPU,
+			MemoryMB: c.MemoryMB,
+			TagIDs:   []string{c.TagID},
+			Timing:   c.Timing,
+		},
+	}
+}
+
+// tagState is the state of a VM recorded in the audit trail around a tag
+// change. TagIDs are the VM's tags in the changed category, and Timing when
+// the change is made.
+type tagState struct {
+	NumCPU   int32    `json:"numCPU"`
+	MemoryMB int32    `json:"memoryMB"`
+	TagIDs   []string `json:"tagIDs"`
+	Timing   string   `json:"timing,omitempty"`
+}
@@ -0,0 +1,395 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	defaultLimitWindow = time.Hour
+	// inFlightTimeout drops changes that were never released, e.g. because
+	// the function was restarted while a task ran.
+	inFlightTimeout = 6 * time.Hour
+	// alertInterval is how often the same limit alerts while it is hit.
+	alertInterval = 5 * time.Minute
+)
+
+// limitsConfig is the optional [limits] section of vcconfig. It keeps a
+// misfiring alarm from changing many VMs at once.
+type limitsConfig struct {
+	// Store is the JSON file the limit state is kept in, shared by all
+	// replicas. Without one, each replica keeps its own state in memory.
+	Store string `toml:"store"`
+	// Window is the period max_vms counts distinct VMs over. Defaults to 1h.
+	Window string `toml:"window"`
+	// AlertWebhook is POSTed a JSON alert when a limit is hit.
+	AlertWebhook string `toml:"alert_webhook"`
+
+	Global  limitSet `toml:"global"`
+	Cluster limitSet `toml:"cluster"`
+}
+
+// limitSet is a set of limits, zero meaning unlimited.
+type limitSet struct {
+	MaxConcurrent int `toml:"max_concurrent"`
+	MaxPerHour    int `toml:"max_per_hour"`
+	MaxVMs        int `toml:"max_vms"`
+}
+
+func (c limitsConfig) enabled() bool {
+	return c.Global != (limitSet{}) || c.Cluster != (limitSet{})
+}
+
+func (c limitsConfig) window() (time.Duration, error) {
+	if c.Window == "" {
+		return defaultLimitWindow, nil
+	}
+
+	d, err := time.ParseDuration(c.Window)
+	if err != nil {
+		return 0, fmt.Errorf("parsing limits window: %w", err)
+	}
+
+	return d, nil
+}
+
+// limitError is returned when a change is refused by a limit.
+type limitError struct {
+	scope  string
+	limit  string
+	reason string
+}
+
+func (e *limitError) Error() string {
+	return e.reason
+}
+
+// limitEntry is a change counted against the limits.
+type limitEntry struct {
+	Time    time.Time `json:"time"`
+	VM      string    `json:"vm"`
+	Cluster string    `json:"cluster"`
+}
+
+// limitState is the changes made recently, and those still in flight.
+type limitState struct {
+	Recent   []limitEntry          `json:"recent"`
+	InFlight map[string]limitEntry `json:"in_flight"`
+	Alerts   map[string]time.Time  `json:"alerts"`
+}
+
+// prune forgets what no longer counts against any limit.
+func (st *limitState) prune(t time.Time, window time.Duration) {
+	keep := window
+	if keep < time.Hour {
+		keep = time.Hour
+	}
+
+	recent := st.Recent[:0]
+	for _, e := range st.Recent {
+		if t.Sub(e.Time) < keep {
+			recent = append(recent, e)
+		}
+	}
+	st.Recent = recent
+
+	for id, e := range st.InFlight {
+		if t.Sub(e.Time) > inFlightTimeout {
+			delete(st.InFlight, id)
+		}
+	}
+}
+
+// check returns the limit of s that another change to vm would break, and
+// why. Only the changes for which in returns true are counted.
+func (s limitSet) check(st *limitState, scope, vm string, t time.Time, window time.Duration, in func(limitEntry) bool) (string, string) {
+	inFlight := 0
+	for _, e := range st.InFlight {
+		if in(e) {
+			inFlight++
+		}
+	}
+
+	if s.MaxConcurrent > 0 && inFlight >= s.MaxConcurrent {
+		return "max_concurrent", fmt.Sprintf("%s limit of %d concurrent changes reached", scope, s.MaxConcurrent)
+	}
+
+	lastHour := 0
+	vms := make(map[string]bool)
+	for _, e := range st.Recent {
+		if !in(e) {
+			continue
+		}
+
+		if t.Sub(e.Time) < time.Hour {
+			lastHour++
+		}
+
+		if t.Sub(e.Time) < window {
+			vms[e.VM] = true
+		}
+	}
+
+	if s.MaxPerHour > 0 && lastHour >= s.MaxPerHour {
+		return "max_per_hour", fmt.Sprintf("%s limit of %d changes per hour reached", scope, s.MaxPerHour)
+	}
+
+	if s.MaxVMs > 0 && !vms[vm] && len(vms) >= s.MaxVMs {
+		return "max_vms", fmt.Sprintf("%s limit of %d VMs changed per %s reached", scope, s.MaxVMs, window)
+	}
+
+	return "", ""
+}
+
+// acquireLimits reserves a change to vm under the limits, or refuses it with
+// a *limitError. release must be called once the change is finished.
+func (clt *vsClient) acquireLimits(ctx context.Context, cfg limitsConfig, vm types.ManagedObjectReference) (release func(), err error) {
+	if !cfg.enabled() {
+		return func() {}, nil
+	}
+
+	window, err := cfg.window()
+	if err != nil {
+		return nil, err
+	}
+
+	var cluster string
+	if cfg.Cluster != (limitSet{}) {
+		ref, err := clt.vmCluster(ctx, vm)
+		if err != nil {
+			return nil, fmt.Errorf("finding vm cluster: %w", err)
+		}
+		cluster = ref.Value
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		refusal *limitError
+		alert   bool
+	)
+
+	store := newLimitStore(cfg.Store)
+	err = store.update(func(st *limitState) {
+		t := now()
+		st.prune(t, window)
+
+		all := func(limitEntry) bool { return true }
+		sameCluster := func(e limitEntry) bool { return e.Cluster == cluster }
+
+		if limit, reason := cfg.Global.check(st, "global", vm.Value, t, window, all); reason != "" {
+			refusal = &limitError{scope: "global", limit: limit, reason: reason}
+		} else if limit, reason := cfg.Cluster.check(st, "cluster "+cluster, vm.Value, t, window, sameCluster); reason != "" {
+			refusal = &limitError{scope: "cluster", limit: limit, reason: reason}
+		}
+
+		if refusal != nil {
+			key := refusal.scope + "/" + cluster + "/" + refusal.limit
+			if t.Sub(st.Alerts[key]) >= alertInterval {
+				st.Alerts[key] = t
+				alert = true
+			}
+
+			return
+		}
+
+		e := limitEntry{Time: t, VM: vm.Value, Cluster: cluster}
+		st.Recent = append(st.Recent, e)
+		st.InFlight[id] = e
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating limit state: %w", err)
+	}
+
+	if refusal != nil {
+		limitRefusals.WithLabelValues(refusal.scope, refusal.limit).Inc()
+		logger(ctx).Warn("change refused by limit", "scope", refusal.scope, "limit", refusal.limit, "reason", refusal.reason)
+
+		if alert && cfg.AlertWebhook != "" {
+			sendLimitAlert(ctx, cfg.AlertWebhook, vm, cluster, refusal)
+		}
+
+		return nil, refusal
+	}
+
+	return func() {
+		err := store.update(func(st *limitState) { delete(st.InFlight, id) })
+		if err != nil {
+			logger(ctx).Error("releasing limit", "error", err)
+		}
+	}, nil
+}
+
+// limitAlert is the body POSTed to the alert webhook.
+type limitAlert struct {
+	Text     string `json:"text"`
+	Function string `json:"function"`
+	VM       string `json:"vm"`
+	Cluster  string `json:"cluster,omitempty"`
+	Scope    string `json:"scope"`
+	Limit    string `json:"limit"`
+	Reason   string `json:"reason"`
+}
+
+func sendLimitAlert(ctx context.Context, webhook string, vm types.ManagedObjectReference, cluster string, refusal *limitError) {
+	body, err := json.Marshal(limitAlert{
+		Text:     fmt.Sprintf("%s refused to change %s: %s. Check the alarm definitions.", functionName, vm.Value, refusal.reason),
+		Function: functionName,
+		VM:       vm.Value,
+		Cluster:  cluster,
+		Scope:    refusal.scope,
+		Limit:    refusal.limit,
+		Reason:   refusal.reason,
+	})
+	if err == nil {
+		err = webhookSink(webhook).write(ctx, body)
+	}
+
+	if err != nil {
+		logger(ctx).Error("sending limit alert", "error", err)
+	}
+}
+
+// vmCluster returns the cluster, or standalone host's compute resource, the
+// VM runs in.
+func (clt *vsClient) vmCluster(ctx context.Context, vm types.ManagedObjectReference) (types.ManagedObjectReference, error) {
+	pc := property.DefaultCollector(clt.govmomi.Client)
+
+	var moVM mo.VirtualMachine
+	err := timedRetrieve(func() error {
+		return pc.RetrieveOne(ctx, vm, []string{"runtime.host"}, &moVM)
+	})
+	if err != nil {
+		return types.ManagedObjectReference{}, err
+	}
+
+	if moVM.Runtime.Host == nil {
+		return types.ManagedObjectReference{}, nil
+	}
+
+	var host mo.HostSystem
+	err = timedRetrieve(func() error {
+		return pc.RetrieveOne(ctx, *moVM.Runtime.Host, []string{"parent"}, &host)
+	})
+	if err != nil {
+		return types.ManagedObjectReference{}, err
+	}
+
+	if host.Parent == nil {
+		return types.ManagedObjectReference{}, nil
+	}
+
+	return *host.Parent, nil
+}
+
+// limitStore holds the limit state.
+type limitStore interface {
+	// update calls fn with the state under an exclusive lock, then saves it.
+	update(fn func(*limitState)) error
+}
+
+func newLimitStore(path string) limitStore {
+	if path == "" {
+		return memoryLimits
+	}
+
+	return fileLimitStore(path)
+}
+
+// memoryLimitStore keeps the state of this replica in memory.
+type memoryLimitStore struct {
+	mu    sync.Mutex
+	state limitState
+}
+
+var memoryLimits = &memoryLimitStore{
+	state: limitState{
+		InFlight: make(map[string]limitEntry),
+		Alerts:   make(map[string]time.Time),
+	},
+}
+
+func (m *memoryLimitStore) update(fn func(*limitState)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fn(&m.state)
+
+	return nil
+}
+
+// fileLimitStore keeps the state in a JSON file shared by all replicas.
+type fileLimitStore string
+
+func (f fileLimitStore) update(fn func(*limitState)) error {
+	var st limitState
+
+	return updateJSONFile(string(f), &st, func() {
+		if st.InFlight == nil {
+			st.InFlight = make(map[string]limitEntry)
+		}
+		if st.Alerts == nil {
+			st.Alerts = make(map[string]time.Time)
+		}
+
+		fn(&st)
+	})
+}
+
+// updateJSONFile decodes the JSON file at path into v, calls fn and saves v
+// back, all under an exclusive flock(2) on a lock file next to it. A missing
+// file leaves v as it is.
+func updateJSONFile(path string, v interface{}, fn func()) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, v); err != nil {
+			return fmt.Errorf("decoding %s: %w", path, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	fn()
+
+	if b, err = json.Marshal(v); err != nil {
+		return err
+	}
+
+	// Replace the file whole, so a crash can't leave it half written.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
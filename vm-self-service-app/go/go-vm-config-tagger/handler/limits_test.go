@@ -0,0 +1,75 @@
+package function
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimitSetCheck(t *testing.T) {
+	at := time.Date(2026, 10, 20, 14, 0, 0, 0, time.UTC)
+	st := &limitState{
+		Recent: []limitEntry{
+			{Time: at.Add(-90 * time.Minute), VM: "vm-1", Cluster: "domain-c1"},
+			{Time: at.Add(-30 * time.Minute), VM: "vm-2", Cluster: "domain-c1"},
+			{Time: at.Add(-10 * time.Minute), VM: "vm-3", Cluster: "domain-c2"},
+		},
+		InFlight: map[string]limitEntry{
+			"a": {Time: at.Add(-time.Minute), VM: "vm-3", Cluster: "domain-c2"},
+		},
+	}
+
+	all := func(limitEntry) bool { return true }
+	c1 := func(e limitEntry) bool { return e.Cluster == "domain-c1" }
+
+	tests := []struct {
+		name   string
+		limits limitSet
+		vm     string
+		window time.Duration
+		in     func(limitEntry) bool
+		want   string
+	}{
+		{"unlimited", limitSet{}, "vm-4", time.Hour, all, ""},
+		{"concurrent reached", limitSet{MaxConcurrent: 1}, "vm-4", time.Hour, all, "max_concurrent"},
+		{"concurrent in other cluster", limitSet{MaxConcurrent: 1}, "vm-4", time.Hour, c1, ""},
+		{"per hour reached", limitSet{MaxPerHour: 2}, "vm-4", time.Hour, all, "max_per_hour"},
+		{"per hour ignores older changes", limitSet{MaxPerHour: 3}, "vm-4", time.Hour, all, ""},
+		{"vms reached", limitSet{MaxVMs: 2}, "vm-4", time.Hour, all, "max_vms"},
+		{"vms counts the window", limitSet{MaxVMs: 2}, "vm-4", 2 * time.Hour, c1, "max_vms"},
+		{"vms allows vm changed before", limitSet{MaxVMs: 2}, "vm-2", time.Hour, all, ""},
+		{"vms in cluster", limitSet{MaxVMs: 2}, "vm-4", time.Hour, c1, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			limit, reason := tc.limits.check(st, "global", tc.vm, at, tc.window, tc.in)
+			if limit != tc.want {
+				t.Errorf("check = %q (%s), want %q", limit, reason, tc.want)
+			}
+		})
+	}
+}
+
+func TestLimitStatePrune(t *testing.T) {
+	at := time.Date(2026, 10, 20, 14, 0, 0, 0, time.UTC)
+	st := &limitState{
+		Recent: []limitEntry{
+			{Time: at.Add(-3 * time.Hour), VM: "vm-1"},
+			{Time: at.Add(-90 * time.Minute), VM: "vm-2"},
+		},
+		InFlight: map[string]limitEntry{
+			"a": {Time: at.Add(-7 * time.Hour), VM: "vm-1"},
+			"b": {Time: at.Add(-time.Hour), VM: "vm-2"},
+		},
+	}
+
+	st.prune(at, 2*time.Hour)
+
+	if len(st.Recent) != 1 || st.Recent[0].VM != "vm-2" {
+		t.Errorf("recent = %+v, want only vm-2", st.Recent)
+	}
+
+	if _, ok := st.InFlight["a"]; ok || len(st.InFlight) != 1 {
+		t.Errorf("in flight = %+v, want only b", st.InFlight)
+	}
+}
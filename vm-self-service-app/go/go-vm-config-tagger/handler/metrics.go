@@ -0,0 +1,154 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	handler "github.com/openfaas/templates-sdk/go-http"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	metricsNamespace = "veba"
+	metricsSubsystem = "vm_config_tagger"
+	metricsJob       = "vm-config-tagger-fn"
+)
+
+// Event outcomes counted by eventsTotal.
+const (
+	outcomeReceived = "received"
+	outcomeIgnored  = "ignored"
+	outcomeActed    = "acted"
+	outcomeFailed   = "failed"
+	outcomePending  = "pending_approval"
+	outcomeDenied   = "denied"
+	outcomeExpired  = "expired"
+	outcomeQueued   = "queued"
+	// outcomeOutOfWindow counts changes only recorded, as they were outside
+	// the maintenance windows.
+	outcomeOutOfWindow = "out_of_window"
+	// outcomeOptedOut counts events for VMs with automation disabled.
+	outcomeOptedOut = "opted_out"
+	// outcomeRateLimited counts changes refused by a limit.
+	outcomeRateLimited = "rate_limited"
+	// outcomeNoCapacity counts increments refused for lack of capacity.
+	outcomeNoCapacity = "no_capacity"
+	// outcomeNoHotAdd counts increments skipped as the running VM can't
+	// take them.
+	outcomeNoHotAdd = "no_hot_add"
+	// outcomeStale counts approved or queued changes dropped as the VM
+	// changed while they waited.
+	outcomeStale = "stale"
+)
+
+var (
+	eventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "events_total",
+		Help:      "Events handled, by alarm name, action and outcome (received, ignored, acted, failed, pending_approval, denied, expired, queued, out_of_window, opted_out, rate_limited, no_capacity, no_hot_add or stale).",
+	}, []string{"alarm", "action", "outcome"})
+
+	loginDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "vcenter_login_duration_seconds",
+		Help:      "Time taken to log into the vCenter SOAP and REST APIs.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	retrieveDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "property_retrieval_duration_seconds",
+		Help:      "Time taken to retrieve properties from the property collector.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	taskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "task_duration_seconds",
+		Help:      "Time taken by the vSphere changes made for an action.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+	}, []string{"action"})
+
+	limitRefusals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "limit_refusals_total",
+		Help:      "Changes refused by a limit, by scope (global or cluster) and limit.",
+	}, []string{"scope", "limit"})
+
+	rollbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "rollbacks_total",
+		Help:      "Failed changes rolled back, by action and result (rolled_back or rollback_failed).",
+	}, []string{"action", "result"})
+
+	registry = prometheus.NewRegistry()
+)
+
+func init() {
+	registry.MustRegister(eventsTotal, loginDuration, retrieveDuration, taskDuration, limitRefusals, rollbacks)
+}
+
+// countEvent increments the event counter for an outcome.
+func countEvent(alarm, action, outcome string) {
+	eventsTotal.WithLabelValues(alarm, action, outcome).Inc()
+}
+
+// observeSince records the time elapsed since start.
+func observeSince(o prometheus.Observer, start time.Time) {
+	o.Observe(time.Since(start).Seconds())
+}
+
+// timedRetrieve runs a property collector retrieval and records how long it
+// took.
+func timedRetrieve(retrieve func() error) error {
+	defer observeSince(retrieveDuration, time.Now())
+
+	return retrieve()
+}
+
+// metricsResponse renders the metrics in the Prometheus text format, so the
+// function can be scraped with a GET request.
+func metricsResponse() (handler.Response, error) {
+	mfs, err := registry.Gather()
+	if err != nil {
+		return errRespondAndLog(context.Background(), err)
+	}
+
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return errRespondAndLog(context.Background(), err)
+		}
+	}
+
+	return handler.Response{
+		Body:       buf.Bytes(),
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{string(expfmt.FmtText)}},
+	}, nil
+}
+
+// pushMetrics pushes the metrics to the pushgateway at metrics_pushgateway,
+// if set.
+func pushMetrics() {
+	url := os.Getenv("metrics_pushgateway")
+	if url == "" {
+		return
+	}
+
+	if err := push.New(url, metricsJob).Gatherer(registry).Push(); err != nil {
+		baseLogger.Warn("pushing metrics failed", "error", err)
+	}
+}
@@ -0,0 +1,87 @@
+package function
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// When a change can be made to a VM.
+const (
+	timingNow        = "now"
+	timingPowerCycle = "power_cycle"
+	timingSkip       = "skip"
+)
+
+// pendingTagName is the tag in the pending category marking VMs with changes
+// waiting for a power cycle.
+const pendingTagName = "power_cycle"
+
+// powerConfig is the optional [power] section of vcconfig. It decides what is
+// done with changes a running VM can't take without a power cycle.
+type powerConfig struct {
+	// WithoutHotAdd is "power_cycle" to attach the tag anyway, so the change
+	// is made at the next power cycle, or "skip" to not attach it. Defaults
+	// to power_cycle.
+	WithoutHotAdd string `toml:"without_hot_add"`
+	// PendingCategory is a tag category with a "power_cycle" tag, attached
+	// alongside changes that wait for a power cycle.
+	PendingCategory string `toml:"pending_category"`
+}
+
+func (c powerConfig) validate() error {
+	switch c.WithoutHotAdd {
+	case "", timingPowerCycle, timingSkip:
+		return nil
+	}
+
+	return fmt.Errorf("power without_hot_add must be %s or %s", timingPowerCycle, timingSkip)
+}
+
+// changeTiming returns when a change in category can be made to the VM, and
+// why.
+func (c powerConfig) changeTiming(moVM mo.VirtualMachine, category string) (string, string) {
+	if moVM.Runtime.PowerState != types.VirtualMachinePowerStatePoweredOn {
+		return timingNow, "the vm is " + string(moVM.Runtime.PowerState)
+	}
+
+	reason := "the vm is powered on"
+
+	switch category {
+	case "config.hardware.numCPU":
+		if hotAdd := moVM.Config.CpuHotAddEnabled; hotAdd != nil && *hotAdd {
+			return timingNow, "cpu hot-add is enabled"
+		}
+		reason += " without cpu hot-add"
+	case "config.hardware.memoryMB":
+		if hotAdd := moVM.Config.MemoryHotAddEnabled; hotAdd != nil && *hotAdd {
+			return timingNow, "memory hot-add is enabled"
+		}
+		reason += " without memory hot-add"
+	}
+
+	if c.WithoutHotAdd == timingSkip {
+		return timingSkip, reason
+	}
+
+	return timingPowerCycle, reason
+}
+
+// pendingTag returns the ID of the power_cycle tag in the pending category.
+func (clt *vsClient) pendingTag(ctx context.Context, category string) (string, error) {
+	ctx, span := startSpan(ctx, "tags.GetTagsForCategory", attribute.String("vsphere.category", category))
+	tagList, err := clt.tagMgr.GetTagsForCategory(ctx, category)
+	endSpan(span, err)
+	if err != nil {
+		return "", err
+	}
+
+	if _, tagID := findCatAndTagIDs(tagList, pendingTagName); tagID != "" {
+		return tagID, nil
+	}
+
+	return "", fmt.Errorf("no %s tag in category %s", pendingTagName, category)
+}
@@ -0,0 +1,47 @@
+package function
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestChangeTiming(t *testing.T) {
+	yes, no := true, false
+
+	vm := func(state types.VirtualMachinePowerState, cpuHotAdd, memHotAdd *bool) mo.VirtualMachine {
+		var moVM mo.VirtualMachine
+		moVM.Runtime.PowerState = state
+		moVM.Config = &types.VirtualMachineConfigInfo{CpuHotAddEnabled: cpuHotAdd, MemoryHotAddEnabled: memHotAdd}
+
+		return moVM
+	}
+
+	on, off := types.VirtualMachinePowerStatePoweredOn, types.VirtualMachinePowerStatePoweredOff
+
+	tests := []struct {
+		name     string
+		cfg      powerConfig
+		vm       mo.VirtualMachine
+		category string
+		want     string
+	}{
+		{"powered off", powerConfig{}, vm(off, nil, nil), "config.hardware.numCPU", timingNow},
+		{"suspended", powerConfig{WithoutHotAdd: timingSkip}, vm(types.VirtualMachinePowerStateSuspended, nil, nil), "config.hardware.numCPU", timingNow},
+		{"cpu hot-add", powerConfig{}, vm(on, &yes, nil), "config.hardware.numCPU", timingNow},
+		{"cpu without hot-add", powerConfig{}, vm(on, &no, &yes), "config.hardware.numCPU", timingPowerCycle},
+		{"cpu hot-add unset", powerConfig{}, vm(on, nil, nil), "config.hardware.numCPU", timingPowerCycle},
+		{"memory hot-add", powerConfig{}, vm(on, &no, &yes), "config.hardware.memoryMB", timingNow},
+		{"memory without hot-add skipped", powerConfig{WithoutHotAdd: timingSkip}, vm(on, &yes, &no), "config.hardware.memoryMB", timingSkip},
+		{"other setting while on", powerConfig{}, vm(on, &yes, &yes), "config.hardware.numCoresPerSocket", timingPowerCycle},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, reason := tc.cfg.changeTiming(tc.vm, tc.category); got != tc.want {
+				t.Errorf("changeTiming = %s (%s), want %s", got, reason, tc.want)
+			}
+		})
+	}
+}
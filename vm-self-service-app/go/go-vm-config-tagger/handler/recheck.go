@@ -0,0 +1,95 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// staleError refuses a change that no longer fits the VM, as the VM changed
+// while the change waited for approval or in the queue.
+type staleError struct {
+	outcome string
+	reason  string
+}
+
+func (e *staleError) Error() string {
+	return e.reason
+}
+
+// recheck checks that a change that waited still fits the VM: automation is
+// still allowed for it, it still has the size the change was planned for, and
+// the capacity and its power state still allow the change. If not, it returns
+// a *staleError.
+func (c tagChange) recheck(ctx context.Context, cfg *vcConfig, clt *vsClient) (err error) {
+	ctx, span := startSpan(ctx, "change.Recheck", vmMoRef(c.VM.Value))
+	defer func() { endSpan(span, err) }()
+
+	if cfg.Automation.enabled() {
+		allowed, reason, err := clt.automationAllowed(ctx, cfg.Automation, c.VM)
+		if err != nil {
+			return fmt.Errorf("checking automation setting: %w", err)
+		}
+
+		if !allowed {
+			return &staleError{outcome: outcomeOptedOut, reason: reason}
+		}
+	}
+
+	start := time.Now()
+	moVM, err := clt.moVirtualMachine(ctx, c.VM)
+	if err != nil {
+		return fmt.Errorf("getting vm configs: %w", err)
+	}
+	observeSince(retrieveDuration, start)
+
+	if hw := moVM.Config.Hardware; hw.NumCPU != c.NumCPU || hw.MemoryMB != c.MemoryMB {
+		return &staleError{
+			outcome: outcomeStale,
+			reason: fmt.Sprintf("the vm changed from %d vCPU(s) and %d MB to %d vCPU(s) and %d MB since",
+				c.NumCPU, c.MemoryMB, hw.NumCPU, hw.MemoryMB),
+		}
+	}
+
+	tag, err := clt.tagMgr.GetTag(ctx, c.TagID)
+	if err != nil {
+		return fmt.Errorf("getting tag: %w", err)
+	}
+
+	cat, err := clt.tagMgr.GetCategory(ctx, tag.CategoryID)
+	if err != nil {
+		return fmt.Errorf("getting category: %w", err)
+	}
+
+	scaling := cat.Name == "config.hardware.numCPU" || cat.Name == "config.hardware.memoryMB"
+	if cfg.Capacity.enabled() && scaling {
+		scopes, err := clt.capacityScopes(ctx, moVM)
+		if err != nil {
+			return fmt.Errorf("checking capacity: %w", err)
+		}
+
+		want, _ := strconv.Atoi(tag.Name)
+		if max, reason := cfg.Capacity.maxValue(cat.Name, scopes); max >= 0 && want > max {
+			return &staleError{outcome: outcomeNoCapacity, reason: reason}
+		}
+	}
+
+	timing, why := cfg.Power.changeTiming(moVM, cat.Name)
+	switch {
+	case timing == timingSkip:
+		return &staleError{outcome: outcomeNoHotAdd, reason: why}
+	case c.Timing != "" && timing != c.Timing:
+		return &staleError{outcome: outcomeStale, reason: "the vm's power state or hot-add setting changed since, " + why}
+	}
+
+	return nil
+}
+
+// refuse records in the audit trail that the change was not made.
+func (c tagChange) refuse(ctx context.Context, cfg *vcConfig, clt *vsClient, reason string) {
+	rec := c.auditRecord(cfg.VCenter.Server)
+	rec.Result = resultRefused
+	rec.Reason = reason
+	newAuditor(cfg.Audit, clt.govmomi.Client).record(ctx, rec)
+}
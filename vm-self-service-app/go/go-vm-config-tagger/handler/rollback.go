@@ -0,0 +1,50 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// step is a change made to vSphere, and how to undo it.
+type step struct {
+	name string
+	undo func(context.Context) error
+}
+
+// transaction records the steps of a change as they are made, so that a
+// change failing part way can be rolled back.
+type transaction struct {
+	steps []step
+}
+
+// done records a step that was made.
+func (tx *transaction) done(name string, undo func(context.Context) error) {
+	tx.steps = append(tx.steps, step{name: name, undo: undo})
+}
+
+// rollback undoes the steps made, last first. It carries on past the steps it
+// can't undo, and returns an error naming them.
+func (tx *transaction) rollback(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "transaction.Rollback")
+	defer func() { endSpan(span, err) }()
+
+	var failed []string
+	for i := len(tx.steps) - 1; i >= 0; i-- {
+		s := tx.steps[i]
+		if err := s.undo(ctx); err != nil {
+			logger(ctx).Error("undoing step", "step", s.name, "error", err)
+			failed = append(failed, fmt.Sprintf("%s: %v", s.name, err))
+			continue
+		}
+
+		logger(ctx).Info("undid step", "step", s.name)
+	}
+	tx.steps = nil
+
+	if len(failed) > 0 {
+		return fmt.Errorf("undoing %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	var undone []string
+	undo := func(name string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			undone = append(undone, name)
+			return err
+		}
+	}
+
+	tx := &transaction{}
+	tx.done("first", undo("first", nil))
+	tx.done("second", undo("second", errors.New("gone")))
+	tx.done("third", undo("third", nil))
+
+	err := tx.rollback(context.Background())
+	if want := "undoing second: gone"; err == nil || err.Error() != want {
+		t.Errorf("rollback() error = %v, want %q", err, want)
+	}
+
+	if want := []string{"third", "second", "first"}; !reflect.DeepEqual(undone, want) {
+		t.Errorf("undone = %v, want %v", undone, want)
+	}
+
+	undone = nil
+	if err := tx.rollback(context.Background()); err != nil || len(undone) > 0 {
+		t.Errorf("second rollback() undid %v, error %v, want nothing", undone, err)
+	}
+}
+
+func TestEventSeverity(t *testing.T) {
+	tests := []struct {
+		result, want string
+	}{
+		{resultSuccess, "info"},
+		{resultFailure, "warning"},
+		{resultRolledBack, "warning"},
+		{resultRollbackFailed, "error"},
+	}
+
+	for _, tc := range tests {
+		if got := eventSeverity(tc.result); got != tc.want {
+			t.Errorf("eventSeverity(%s) = %q, want %q", tc.result, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package function
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+const defaultRuleWithin = 24 * time.Hour
+
+// mappingConfig is the optional [mapping] section of vcconfig. Its rules map
+// alarms to changes of the taggen categories. Without rules, CPU and memory
+// usage alarms scale the VM.
+type mappingConfig struct {
+	// Store is the JSON file the alarm history counted by repeats is kept
+	// in, shared by all replicas. Without one, each replica keeps its own
+	// history in memory.
+	Store string `toml:"store"`
+	Rules []rule `toml:"rules"`
+}
+
+// rule maps an alarm turning to a status to a tag in a taggen category.
+type rule struct {
+	// Alarm is the alarm name, e.g. "VM CPU Usage".
+	Alarm string `toml:"alarm"`
+	// Status is the status the alarm turns to. Defaults to red.
+	Status string `toml:"status"`
+	// Category is the taggen category, e.g. "config.cpuHotAddEnabled".
+	Category string `toml:"category"`
+	// Value is the preset to tag the VM with. Without one, the VM steps up
+	// to its next size.
+	Value string `toml:"value"`
+	// Repeats is how many times the alarm must have fired for the VM within
+	// Within, this time included, before the rule applies.
+	Repeats int    `toml:"repeats"`
+	Within  string `toml:"within"`
+}
+
+// defaultRules are the rules used when none are configured.
+var defaultRules = []rule{
+	{Alarm: "VM CPU Usage", Category: "config.hardware.numCPU"},
+	{Alarm: "VM Memory Usage", Category: "config.hardware.memoryMB"},
+}
+
+func (c mappingConfig) rules() []rule {
+	if len(c.Rules) == 0 {
+		return defaultRules
+	}
+
+	return c.Rules
+}
+
+// validate checks what can be checked without vCenter. The values are
+// checked against the category's tags when a rule is applied.
+func (c mappingConfig) validate() error {
+	for i, r := range c.Rules {
+		switch {
+		case r.Alarm == "" || r.Category == "":
+			return fmt.Errorf("mapping rule %d: required field(s) missing, including alarm and category", i+1)
+		case r.Value == "" && !r.steps():
+			return fmt.Errorf("mapping rule %d: %s needs a value", i+1, r.Category)
+		}
+
+		if _, err := r.within(); err != nil {
+			return fmt.Errorf("mapping rule %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// matchingRules returns the rules for an alarm turning to status, in order.
+func matchingRules(rules []rule, alarm, status string) []rule {
+	var matched []rule
+	for _, r := range rules {
+		want := r.Status
+		if want == "" {
+			want = "red"
+		}
+
+		if r.Alarm == alarm && want == status {
+			matched = append(matched, r)
+		}
+	}
+
+	return matched
+}
+
+// steps reports whether the rule's category has a next value to step up to
+// when the rule has none.
+func (r rule) steps() bool {
+	switch r.Category {
+	case "config.hardware.numCPU", "config.hardware.memoryMB", "config.hardware.numCoresPerSocket":
+		return true
+	}
+
+	return false
+}
+
+// action is the action taken by the rule, used to label metrics.
+func (r rule) action() string {
+	switch r.Category {
+	case "config.hardware.numCPU":
+		return actionScaleCPU
+	case "config.hardware.memoryMB":
+		return actionScaleMemory
+	}
+
+	return "set_" + r.Category[strings.LastIndex(r.Category, ".")+1:]
+}
+
+func (r rule) within() (time.Duration, error) {
+	if r.Within == "" {
+		return defaultRuleWithin, nil
+	}
+
+	d, err := time.ParseDuration(r.Within)
+	if err != nil {
+		return 0, fmt.Errorf("parsing within: %w", err)
+	}
+
+	return d, nil
+}
+
+// repeated reports whether the alarm fired often enough at the times in
+// fired for the rule to apply at t.
+func (r rule) repeated(fired []time.Time, t time.Time) bool {
+	if r.Repeats <= 1 {
+		return true
+	}
+
+	within, _ := r.within()
+
+	n := 0
+	for _, f := range fired {
+		if t.Sub(f) < within {
+			n++
+		}
+	}
+
+	return n >= r.Repeats
+}
+
+// longestRepeatWindow returns the longest window repeats are counted over by
+// the rules, or zero if none counts repeats.
+func longestRepeatWindow(rules []rule) time.Duration {
+	var longest time.Duration
+	for _, r := range rules {
+		if within, _ := r.within(); r.Repeats > 1 && within > longest {
+			longest = within
+		}
+	}
+
+	return longest
+}
+
+// target returns the tag name the rule sets on the VM, or "" if there is no
+// change to make. tagNames are the names of the tags in the rule's category,
+// and guest the guest OS maximums that vCPU and memory steps stay within.
+func (r rule) target(moVM mo.VirtualMachine, tagNames []string, guest hardwareMax) string {
+	current := currentValue(moVM, r.Category)
+
+	target := r.Value
+	if target == "" {
+		s := vmScaling(moVM)
+		s.guest = guest
+
+		switch r.Category {
+		case "config.hardware.numCPU":
+			if n, ok := s.nextCPU(); ok {
+				target = strconv.Itoa(n)
+			}
+		case "config.hardware.memoryMB":
+			if n, ok := s.nextMemory(); ok {
+				target = strconv.Itoa(n)
+			}
+		case "config.hardware.numCoresPerSocket":
+			target = nextCoresPerSocket(s.numCPU, s.coresPerSocket, tagNames)
+		}
+	}
+
+	if target == current {
+		return ""
+	}
+
+	return target
+}
+
+// nextCoresPerSocket returns the smallest of the tag names above cps that the
+// vCPUs can be split into whole sockets of, or "".
+func nextCoresPerSocket(numCPU, cps int, tagNames []string) string {
+	next, target := 0, ""
+	for _, name := range tagNames {
+		n, err := strconv.Atoi(name)
+		if err == nil && n > cps && numCPU%n == 0 && (next == 0 || n < next) {
+			next, target = n, name
+		}
+	}
+
+	return target
+}
+
+// currentValue returns the VM's value of the property the category is named
+// after, formatted like its tags, or "" if the VM has none. An unset flag is
+// false.
+func currentValue(moVM mo.VirtualMachine, category string) string {
+	v := reflect.ValueOf(moVM)
+	for _, name := range strings.Split(category, ".") {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return unsetValue(v.Type())
+			}
+			v = v.Elem()
+		}
+
+		if v.Kind() != reflect.Struct {
+			return ""
+		}
+
+		if v = fieldByAPIName(v, name); !v.IsValid() {
+			return ""
+		}
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return unsetValue(v.Type())
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Float32, reflect.Float64:
+		return fmt.Sprint(v.Interface())
+	}
+
+	return ""
+}
+
+// unsetValue is the value of an unset property of type t: false for flags,
+// else none.
+func unsetValue(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Bool {
+		return "false"
+	}
+
+	return ""
+}
+
+// fieldByAPIName returns the field of struct v named name in the vSphere API,
+// looking into embedded structs. Depending on the govmomi version, managed
+// object fields carry the name in their mo or json tag.
+func fieldByAPIName(v reflect.Value, name string) reflect.Value {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		tag := f.Tag.Get("mo")
+		if tag == "" {
+			tag = f.Tag.Get("xml")
+		}
+		if tag == "" {
+			tag = f.Tag.Get("json")
+		}
+		if strings.Split(tag, ",")[0] == name {
+			return v.Field(i)
+		}
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if ev := fieldByAPIName(v.Field(i), name); ev.IsValid() {
+				return ev
+			}
+		}
+	}
+
+	return reflect.Value{}
+}
+
+// alarmHistory is when each alarm fired for each VM, keyed by "vm/alarm".
+type alarmHistory struct {
+	Fired map[string][]time.Time `json:"fired"`
+}
+
+// memoryHistory is the history of this replica when there is no store.
+var memoryHistory = struct {
+	sync.Mutex
+	alarmHistory
+}{alarmHistory: alarmHistory{Fired: make(map[string][]time.Time)}}
+
+// recordFiring adds the alarm firing at t to the history, forgetting
+// firings older than keep, and returns when the alarm fired for the VM.
+func recordFiring(store, vm, alarm string, t time.Time, keep time.Duration) ([]time.Time, error) {
+	var fired []time.Time
+
+	record := func(h *alarmHistory) {
+		if h.Fired == nil {
+			h.Fired = make(map[string][]time.Time)
+		}
+
+		for key, times := range h.Fired {
+			recent := times[:0]
+			for _, f := range times {
+				if t.Sub(f) < keep {
+					recent = append(recent, f)
+				}
+			}
+
+			if len(recent) == 0 {
+				delete(h.Fired, key)
+			} else {
+				h.Fired[key] = recent
+			}
+		}
+
+		key := vm + "/" + alarm
+		h.Fired[key] = append(h.Fired[key], t)
+		fired = append(fired, h.Fired[key]...)
+	}
+
+	if store == "" {
+		memoryHistory.Lock()
+		defer memoryHistory.Unlock()
+		record(&memoryHistory.alarmHistory)
+
+		return fired, nil
+	}
+
+	var h alarmHistory
+	if err := updateJSONFile(store, &h, func() { record(&h) }); err != nil {
+		return nil, fmt.Errorf("updating alarm history: %w", err)
+	}
+
+	return fired, nil
+}
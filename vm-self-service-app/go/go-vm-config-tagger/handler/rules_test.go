@@ -0,0 +1,160 @@
+package function
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestMatchingRules(t *testing.T) {
+	rules := []rule{
+		{Alarm: "VM CPU Usage", Category: "config.cpuHotAddEnabled", Value: "true", Repeats: 3},
+		{Alarm: "VM CPU Usage", Category: "config.hardware.numCPU"},
+		{Alarm: "VM CPU Usage", Status: "yellow", Category: "config.hardware.numCoresPerSocket"},
+	}
+
+	tests := []struct {
+		alarm, status string
+		want          []string
+	}{
+		{"VM CPU Usage", "red", []string{"set_cpuHotAddEnabled", "scale_cpu"}},
+		{"VM CPU Usage", "yellow", []string{"set_numCoresPerSocket"}},
+		{"VM Memory Usage", "red", nil},
+	}
+
+	for _, tc := range tests {
+		var got []string
+		for _, r := range matchingRules(rules, tc.alarm, tc.status) {
+			got = append(got, r.action())
+		}
+
+		if len(got) != len(tc.want) || (len(got) > 0 && got[0] != tc.want[0]) {
+			t.Errorf("matchingRules(%s, %s) = %v, want %v", tc.alarm, tc.status, got, tc.want)
+		}
+	}
+}
+
+func TestRuleTarget(t *testing.T) {
+	yes := true
+
+	vm := func(numCPU, cps, memoryMB int32, cpuHotAdd *bool) mo.VirtualMachine {
+		var moVM mo.VirtualMachine
+		moVM.Config = &types.VirtualMachineConfigInfo{Version: "vmx-19", CpuHotAddEnabled: cpuHotAdd}
+		moVM.Config.Hardware = types.VirtualHardware{NumCPU: numCPU, NumCoresPerSocket: cps, MemoryMB: memoryMB}
+
+		return moVM
+	}
+
+	tests := []struct {
+		name  string
+		r     rule
+		vm    mo.VirtualMachine
+		guest hardwareMax
+		want  string
+	}{
+		{"next cpu", rule{Category: "config.hardware.numCPU"}, vm(2, 1, 1024, nil), hardwareMax{}, "3"},
+		{"next memory", rule{Category: "config.hardware.memoryMB"}, vm(2, 1, 6000, nil), hardwareMax{}, "8192"},
+		{"cpu at guest os maximum", rule{Category: "config.hardware.numCPU"}, vm(2, 1, 1024, nil), hardwareMax{cpus: 2}, ""},
+		{"memory over guest os maximum", rule{Category: "config.hardware.memoryMB"}, vm(2, 1, 6000, nil), hardwareMax{memoryMB: 6144}, ""},
+		{"enable hot-add", rule{Category: "config.cpuHotAddEnabled", Value: "true"}, vm(2, 1, 1024, nil), hardwareMax{}, "true"},
+		{"hot-add already enabled", rule{Category: "config.cpuHotAddEnabled", Value: "true"}, vm(2, 1, 1024, &yes), hardwareMax{}, ""},
+		{"disable unset hot-add", rule{Category: "config.memoryHotAddEnabled", Value: "false"}, vm(2, 1, 1024, nil), hardwareMax{}, ""},
+		{"next cores per socket", rule{Category: "config.hardware.numCoresPerSocket"}, vm(4, 1, 1024, nil), hardwareMax{}, "2"},
+		{"cores per socket skips uneven", rule{Category: "config.hardware.numCoresPerSocket"}, vm(6, 2, 1024, nil), hardwareMax{}, "3"},
+		{"no cores per socket fits", rule{Category: "config.hardware.numCoresPerSocket"}, vm(5, 1, 1024, nil), hardwareMax{}, ""},
+		{"preset value", rule{Category: "config.hardware.numCoresPerSocket", Value: "2"}, vm(4, 1, 1024, nil), hardwareMax{}, "2"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.r.target(tc.vm, []string{"4", "1", "3", "2"}, tc.guest); got != tc.want {
+				t.Errorf("target = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRuleRepeated(t *testing.T) {
+	at := time.Date(2026, 10, 20, 14, 0, 0, 0, time.UTC)
+	fired := []time.Time{at.Add(-3 * time.Hour), at.Add(-time.Hour), at}
+
+	tests := []struct {
+		name string
+		r    rule
+		want bool
+	}{
+		{"no repeats", rule{}, true},
+		{"enough repeats", rule{Repeats: 3}, true},
+		{"too few repeats", rule{Repeats: 4}, false},
+		{"repeats within window", rule{Repeats: 3, Within: "2h"}, false},
+	}
+
+	for _, tc := range tests {
+		if got := tc.r.repeated(fired, at); got != tc.want {
+			t.Errorf("%s: repeated = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMappingValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		r    rule
+	}{
+		{"no alarm", rule{Category: "config.hardware.numCPU"}},
+		{"no category", rule{Alarm: "VM CPU Usage"}},
+		{"flag without value", rule{Alarm: "VM CPU Usage", Category: "config.cpuHotAddEnabled"}},
+		{"property without value", rule{Alarm: "VM CPU Usage", Category: "config.latencySensitivity.level"}},
+		{"bad within", rule{Alarm: "VM CPU Usage", Category: "config.hardware.numCPU", Repeats: 2, Within: "a day"}},
+	}
+
+	for _, tc := range tests {
+		if err := (mappingConfig{Rules: []rule{tc.r}}).validate(); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+
+	// Categories and values are checked against vCenter, so any taggen
+	// category and preset passes.
+	valid := mappingConfig{Rules: []rule{
+		{Alarm: "VM Memory Usage", Category: "config.hardware.memoryMB", Value: "32768"},
+		{Alarm: "VM CPU Usage", Category: "config.latencySensitivity.level", Value: "high"},
+	}}
+	if err := valid.validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+}
+
+func TestCurrentValue(t *testing.T) {
+	yes := true
+
+	var moVM mo.VirtualMachine
+	moVM.Config = &types.VirtualMachineConfigInfo{
+		GuestId:            "otherGuest",
+		CpuHotAddEnabled:   &yes,
+		LatencySensitivity: &types.LatencySensitivity{Level: "high"},
+	}
+	moVM.Config.Hardware = types.VirtualHardware{NumCPU: 4, MemoryMB: 32768}
+
+	tests := []struct {
+		category, want string
+	}{
+		{"config.hardware.numCPU", "4"},
+		{"config.hardware.memoryMB", "32768"},
+		{"config.cpuHotAddEnabled", "true"},
+		{"config.memoryHotAddEnabled", "false"},
+		{"config.guestId", "otherGuest"},
+		{"config.latencySensitivity.level", "high"},
+		{"config.cpuAllocation.limit", ""},
+		{"config.hardware", ""},
+		{"config.nope", ""},
+	}
+
+	for _, tc := range tests {
+		if got := currentValue(moVM, tc.category); got != tc.want {
+			t.Errorf("currentValue(%s) = %q, want %q", tc.category, got, tc.want)
+		}
+	}
+}
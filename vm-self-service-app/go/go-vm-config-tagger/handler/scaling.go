@@ -0,0 +1,178 @@
+package function
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// hardwareMax is the most vCPUs and memory a virtual hardware version
+// supports.
+type hardwareMax struct {
+	cpus     int
+	memoryMB int
+}
+
+// hardwareMaxima are the VM maximums of each virtual hardware version that
+// raised them, from the vSphere configuration maximums.
+var hardwareMaxima = map[int]hardwareMax{
+	4:  {cpus: 4, memoryMB: 64 << 10},
+	7:  {cpus: 8, memoryMB: 255 << 10},
+	8:  {cpus: 32, memoryMB: 1011 << 10},
+	9:  {cpus: 64, memoryMB: 1011 << 10},
+	11: {cpus: 128, memoryMB: 4080 << 10},
+	13: {cpus: 128, memoryMB: 6128 << 10},
+	15: {cpus: 256, memoryMB: 6128 << 10},
+	18: {cpus: 768, memoryMB: 24 << 20},
+}
+
+// hardwareLimits returns the maximums of the hardware version, that is of the
+// latest version up to it that raised them. Versions older than any known
+// get the oldest maximums.
+func hardwareLimits(version int) hardwareMax {
+	best, oldest := -1, -1
+	for v := range hardwareMaxima {
+		if v <= version && v > best {
+			best = v
+		}
+		if oldest < 0 || v < oldest {
+			oldest = v
+		}
+	}
+
+	if best < 0 {
+		best = oldest
+	}
+
+	return hardwareMaxima[best]
+}
+
+// scaling is the VM configuration its next size is calculated from.
+type scaling struct {
+	hwVersion      int
+	numCPU         int
+	coresPerSocket int
+	memoryMB       int
+	// hotAddMB is the memory hot-add increment when memory is hot added to
+	// the running VM, and hotAddLimitMB the most it can be hot added to.
+	hotAddMB      int
+	hotAddLimitMB int
+	// guest is the most vCPUs and memory the guest OS supports, zero where
+	// unknown.
+	guest hardwareMax
+}
+
+// vmScaling returns the scaling of the VM.
+func vmScaling(moVM mo.VirtualMachine) scaling {
+	hw := moVM.Config.Hardware
+
+	s := scaling{
+		numCPU:         int(hw.NumCPU),
+		coresPerSocket: int(hw.NumCoresPerSocket),
+		memoryMB:       int(hw.MemoryMB),
+	}
+
+	// An unknown version, e.g. "vmx-latest", gets the latest maximums.
+	s.hwVersion, _ = strconv.Atoi(strings.TrimPrefix(moVM.Config.Version, "vmx-"))
+	if s.hwVersion == 0 {
+		s.hwVersion = int(^uint(0) >> 1)
+	}
+
+	hotAdd := moVM.Config.MemoryHotAddEnabled != nil && *moVM.Config.MemoryHotAddEnabled
+	if hotAdd && moVM.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn {
+		s.hotAddMB = int(moVM.Config.HotPlugMemoryIncrementSize)
+		s.hotAddLimitMB = int(moVM.Config.HotPlugMemoryLimit)
+	}
+
+	return s
+}
+
+// limits returns the most vCPUs and memory the VM can be given: the
+// maximums of its hardware version, lowered to those of its guest OS.
+func (s scaling) limits() hardwareMax {
+	max := hardwareLimits(s.hwVersion)
+	if s.guest.cpus > 0 && s.guest.cpus < max.cpus {
+		max.cpus = s.guest.cpus
+	}
+	if s.guest.memoryMB > 0 && s.guest.memoryMB < max.memoryMB {
+		max.memoryMB = s.guest.memoryMB
+	}
+
+	return max
+}
+
+// nextCPU returns the next vCPU count, a whole socket more so the topology
+// stays valid. It is false if the VM can't be given more vCPUs.
+func (s scaling) nextCPU() (int, bool) {
+	cps := s.coresPerSocket
+	if cps < 1 {
+		cps = 1
+	}
+
+	next := (s.numCPU/cps + 1) * cps
+	if next > s.limits().cpus {
+		return s.numCPU, false
+	}
+
+	return next, true
+}
+
+// nextMemory returns the next memory size in MB: the next power of two, or
+// when memory is hot added, the least whole number of hot-add increments
+// that reaches it. It is false if the VM can't be given more memory.
+func (s scaling) nextMemory() (int, bool) {
+	next := 1
+	for next <= s.memoryMB {
+		next <<= 1
+	}
+
+	if s.hotAddMB > 0 {
+		added := next - s.memoryMB
+		next = s.memoryMB + (added+s.hotAddMB-1)/s.hotAddMB*s.hotAddMB
+
+		if s.hotAddLimitMB > 0 && next > s.hotAddLimitMB {
+			return s.memoryMB, false
+		}
+	}
+
+	if next > s.limits().memoryMB {
+		return s.memoryMB, false
+	}
+
+	return next, true
+}
+
+// guestLimits returns the most vCPUs and memory the VM's guest OS supports on
+// its hardware version, as the VM's environment browser describes it. Limits
+// it doesn't give are zero.
+func (c *vsClient) guestLimits(ctx context.Context, moVM mo.VirtualMachine) (max hardwareMax, err error) {
+	if moVM.EnvironmentBrowser.Value == "" || moVM.Config.GuestId == "" {
+		return max, nil
+	}
+
+	ctx, span := startSpan(ctx, "EnvironmentBrowser.QueryConfigOption", vmMoRef(moVM.Self.Value))
+	defer func() { endSpan(span, err) }()
+
+	opt, err := object.NewEnvironmentBrowser(c.govmomi.Client, moVM.EnvironmentBrowser).QueryConfigOption(ctx, &types.EnvironmentBrowserConfigOptionQuerySpec{
+		Key:     moVM.Config.Version,
+		GuestId: []string{moVM.Config.GuestId},
+	})
+	if err != nil {
+		return max, err
+	}
+	if opt == nil {
+		return max, nil
+	}
+
+	for _, d := range opt.GuestOSDescriptor {
+		if d.Id == moVM.Config.GuestId {
+			return hardwareMax{cpus: int(d.SupportedMaxCPUs), memoryMB: int(d.SupportedMaxMemMB)}, nil
+		}
+	}
+
+	return max, nil
+}
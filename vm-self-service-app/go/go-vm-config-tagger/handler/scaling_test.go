@@ -0,0 +1,96 @@
+package function
+
+import "testing"
+
+func TestScalingNextCPU(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      scaling
+		want   int
+		wantOK bool
+	}{
+		{"one core per socket", scaling{hwVersion: 19, numCPU: 1, coresPerSocket: 1}, 2, true},
+		{"cores per socket unset", scaling{hwVersion: 19, numCPU: 3}, 4, true},
+		{"adds a whole socket", scaling{hwVersion: 19, numCPU: 2, coresPerSocket: 2}, 4, true},
+		{"three cores per socket", scaling{hwVersion: 19, numCPU: 6, coresPerSocket: 3}, 9, true},
+		{"fixes uneven topology", scaling{hwVersion: 19, numCPU: 3, coresPerSocket: 2}, 4, true},
+		{"at hardware maximum", scaling{hwVersion: 4, numCPU: 4, coresPerSocket: 1}, 4, false},
+		{"socket over hardware maximum", scaling{hwVersion: 7, numCPU: 6, coresPerSocket: 3}, 6, false},
+		{"above hardware maximum never shrinks", scaling{hwVersion: 4, numCPU: 8, coresPerSocket: 1}, 8, false},
+		{"version between maxima", scaling{hwVersion: 10, numCPU: 64, coresPerSocket: 1}, 64, false},
+		{"version raising maximum", scaling{hwVersion: 11, numCPU: 64, coresPerSocket: 1}, 65, true},
+		{"older than any known version", scaling{hwVersion: 3, numCPU: 3, coresPerSocket: 1}, 4, true},
+		{"below guest os maximum", scaling{hwVersion: 19, numCPU: 2, coresPerSocket: 1, guest: hardwareMax{cpus: 4}}, 3, true},
+		{"at guest os maximum", scaling{hwVersion: 19, numCPU: 4, coresPerSocket: 1, guest: hardwareMax{cpus: 4}}, 4, false},
+		{"socket over guest os maximum", scaling{hwVersion: 19, numCPU: 4, coresPerSocket: 2, guest: hardwareMax{cpus: 5}}, 4, false},
+		{"guest os maximum above hardware maximum", scaling{hwVersion: 4, numCPU: 4, coresPerSocket: 1, guest: hardwareMax{cpus: 64}}, 4, false},
+		{"unknown guest os maximum", scaling{hwVersion: 19, numCPU: 4, coresPerSocket: 1, guest: hardwareMax{memoryMB: 1024}}, 5, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := tc.s.nextCPU()
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("nextCPU = %d, %v, want %d, %v", got, ok, tc.want, tc.wantOK)
+			}
+			if got < tc.s.numCPU {
+				t.Errorf("nextCPU = %d, shrinks from %d", got, tc.s.numCPU)
+			}
+		})
+	}
+}
+
+func TestScalingNextMemory(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      scaling
+		want   int
+		wantOK bool
+	}{
+		{"power of two", scaling{hwVersion: 19, memoryMB: 1024}, 2048, true},
+		{"below power of two", scaling{hwVersion: 19, memoryMB: 1000}, 1024, true},
+		{"between powers of two", scaling{hwVersion: 19, memoryMB: 6000}, 8192, true},
+		{"just above power of two", scaling{hwVersion: 19, memoryMB: 4100}, 8192, true},
+		{"hot add on the grid", scaling{hwVersion: 19, memoryMB: 1536, hotAddMB: 512}, 2048, true},
+		{"hot add rounded up to the grid", scaling{hwVersion: 19, memoryMB: 1536, hotAddMB: 1024}, 2560, true},
+		{"hot add limit", scaling{hwVersion: 19, memoryMB: 4096, hotAddMB: 128, hotAddLimitMB: 6144}, 4096, false},
+		{"at hardware maximum", scaling{hwVersion: 4, memoryMB: 64 << 10}, 64 << 10, false},
+		{"above hardware maximum never shrinks", scaling{hwVersion: 7, memoryMB: 300 << 10}, 300 << 10, false},
+		{"within newer maximum", scaling{hwVersion: 8, memoryMB: 300 << 10}, 512 << 10, true},
+		{"within guest os maximum", scaling{hwVersion: 19, memoryMB: 4096, guest: hardwareMax{memoryMB: 8192}}, 8192, true},
+		{"over guest os maximum", scaling{hwVersion: 19, memoryMB: 6000, guest: hardwareMax{memoryMB: 6144}}, 6000, false},
+		{"hot add over guest os maximum", scaling{hwVersion: 19, memoryMB: 1536, hotAddMB: 1024, guest: hardwareMax{memoryMB: 2048}}, 1536, false},
+		{"unknown guest os maximum", scaling{hwVersion: 19, memoryMB: 4096, guest: hardwareMax{cpus: 2}}, 8192, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := tc.s.nextMemory()
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("nextMemory = %d, %v, want %d, %v", got, ok, tc.want, tc.wantOK)
+			}
+			if got < tc.s.memoryMB {
+				t.Errorf("nextMemory = %d, shrinks from %d", got, tc.s.memoryMB)
+			}
+		})
+	}
+}
+
+func TestHardwareLimits(t *testing.T) {
+	tests := []struct {
+		version int
+		want    hardwareMax
+	}{
+		{3, hardwareMaxima[4]},
+		{4, hardwareMaxima[4]},
+		{10, hardwareMaxima[9]},
+		{14, hardwareMaxima[13]},
+		{21, hardwareMaxima[18]},
+	}
+
+	for _, tc := range tests {
+		if got := hardwareLimits(tc.version); got != tc.want {
+			t.Errorf("hardwareLimits(%d) = %+v, want %+v", tc.version, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package function
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vapi/rest"
+	_ "github.com/vmware/govmomi/vapi/simulator"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// simEnv is an in-process vCenter (vcsim) that Handle is pointed at through
+// a temporary vcconfig.
+type simEnv struct {
+	t      *testing.T
+	ctx    context.Context
+	client *govmomi.Client
+	tagMgr *tags.Manager
+	vm     types.ManagedObjectReference
+
+	server, user, pass string
+}
+
+// newSimEnv starts vcsim with the default VPX inventory and writes a vcconfig
+// for it. Everything is torn down when the test ends.
+func newSimEnv(t *testing.T) *simEnv {
+	t.Helper()
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		t.Fatalf("creating simulator model: %v", err)
+	}
+
+	// The tagging API is served under /rest, which vcsim only registers
+	// when asked to.
+	model.Service.RegisterEndpoints = true
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	t.Cleanup(func() {
+		server.Close()
+		model.Remove()
+	})
+
+	gc, err := govmomi.NewClient(ctx, server.URL, true)
+	if err != nil {
+		t.Fatalf("connecting to simulator: %v", err)
+	}
+
+	rc := rest.NewClient(gc.Client)
+	if err := rc.Login(ctx, server.URL.User); err != nil {
+		t.Fatalf("logging into simulator rest api: %v", err)
+	}
+
+	env := &simEnv{
+		t:      t,
+		ctx:    ctx,
+		client: gc,
+		tagMgr: tags.NewManager(rc),
+		vm:     simulator.Map.Any("VirtualMachine").Reference(),
+	}
+
+	env.server = server.URL.Host
+	env.user = server.URL.User.Username()
+	env.pass, _ = server.URL.User.Password()
+	env.writeConfig(env.server, env.user, env.pass)
+
+	return env
+}
+
+// writeConfig writes a vcconfig.toml, followed by any extra sections, and
+// points secret_mount_path at it.
+func (e *simEnv) writeConfig(server, user, pass string, extra ...string) {
+	e.t.Helper()
+
+	dir, err := ioutil.TempDir("", "vcconfig")
+	if err != nil {
+		e.t.Fatalf("creating config dir: %v", err)
+	}
+
+	cfg := fmt.Sprintf("[vcenter]\nserver = %q\nuser = %q\npassword = %q\ninsecure = true\n", server, user, pass)
+	cfg += strings.Join(extra, "\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "vcconfig"), []byte(cfg), 0600); err != nil {
+		e.t.Fatalf("writing vcconfig: %v", err)
+	}
+
+	orig, set := os.LookupEnv("secret_mount_path")
+	os.Setenv("secret_mount_path", dir)
+	e.t.Cleanup(func() {
+		if set {
+			os.Setenv("secret_mount_path", orig)
+		} else {
+			os.Unsetenv("secret_mount_path")
+		}
+		os.RemoveAll(dir)
+	})
+}
+
+// createCategory creates a category the way taggen does, with one tag per
+// name. It returns the tag IDs keyed by tag name.
+func (e *simEnv) createCategory(name string, tagNames ...string) map[string]string {
+	e.t.Helper()
+
+	catID, err := e.tagMgr.CreateCategory(e.ctx, &tags.Category{
+		AssociableTypes: []string{"VirtualMachine"},
+		Cardinality:     "SINGLE",
+		Name:            name,
+	})
+	if err != nil {
+		e.t.Fatalf("creating category %s: %v", name, err)
+	}
+
+	ids := make(map[string]string)
+	for _, n := range tagNames {
+		id, err := e.tagMgr.CreateTag(e.ctx, &tags.Tag{CategoryID: catID, Name: n})
+		if err != nil {
+			e.t.Fatalf("creating tag %s: %v", n, err)
+		}
+		ids[n] = id
+	}
+
+	return ids
+}
+
+// attachTag attaches a tag to the test VM.
+func (e *simEnv) attachTag(tagID string) {
+	e.t.Helper()
+	e.tagObject(e.vm, tagID)
+}
+
+// tagObject attaches a tag to any inventory object.
+func (e *simEnv) tagObject(ref types.ManagedObjectReference, tagID string) {
+	e.t.Helper()
+
+	if err := e.tagMgr.AttachTag(e.ctx, tagID, ref); err != nil {
+		e.t.Fatalf("attaching tag %s to %s: %v", tagID, ref.Value, err)
+	}
+}
+
+// setAttribute sets a custom attribute on an inventory object, defining it
+// if needed.
+func (e *simEnv) setAttribute(ref types.ManagedObjectReference, name, value string) {
+	e.t.Helper()
+
+	m, err := object.GetCustomFieldsManager(e.client.Client)
+	if err != nil {
+		e.t.Fatalf("getting custom fields manager: %v", err)
+	}
+
+	key, err := m.FindKey(e.ctx, name)
+	if err == object.ErrKeyNameNotFound {
+		var def *types.CustomFieldDef
+		def, err = m.Add(e.ctx, name, "", nil, nil)
+		if def != nil {
+			key = def.Key
+		}
+	}
+	if err != nil {
+		e.t.Fatalf("defining attribute %s: %v", name, err)
+	}
+
+	if err := m.Set(e.ctx, ref, key, value); err != nil {
+		e.t.Fatalf("setting attribute %s on %s: %v", name, ref.Value, err)
+	}
+}
+
+// attachedTagNames lists the names of the tags attached to the test VM.
+func (e *simEnv) attachedTagNames() []string {
+	e.t.Helper()
+
+	attached, err := e.tagMgr.GetAttachedTags(e.ctx, e.vm)
+	if err != nil {
+		e.t.Fatalf("listing attached tags: %v", err)
+	}
+
+	var names []string
+	for _, t := range attached {
+		names = append(names, t.Name)
+	}
+
+	return names
+}
+
+// reconfigure applies spec to the test VM and waits for the task.
+func (e *simEnv) reconfigure(spec types.VirtualMachineConfigSpec) {
+	e.t.Helper()
+
+	task, err := object.NewVirtualMachine(e.client.Client, e.vm).Reconfigure(e.ctx, spec)
+	if err != nil {
+		e.t.Fatalf("reconfiguring vm: %v", err)
+	}
+
+	if err := task.Wait(e.ctx); err != nil {
+		e.t.Fatalf("waiting for reconfigure: %v", err)
+	}
+}
+
+// guestOS makes the test VM's environment browser describe its guest OS with
+// the given maximums, which vcsim leaves out.
+func (e *simEnv) guestOS(maxCPUs, maxMemMB int32) {
+	e.t.Helper()
+
+	vm := e.moVM()
+	simulator.Map.Put(&environmentBrowser{
+		EnvironmentBrowser: mo.EnvironmentBrowser{Self: vm.EnvironmentBrowser},
+		descriptor:         types.GuestOsDescriptor{Id: vm.Config.GuestId, SupportedMaxCPUs: maxCPUs, SupportedMaxMemMB: maxMemMB},
+	})
+}
+
+// environmentBrowser is a vcsim EnvironmentBrowser that describes a single
+// guest OS.
+type environmentBrowser struct {
+	mo.EnvironmentBrowser
+
+	descriptor types.GuestOsDescriptor
+}
+
+func (b *environmentBrowser) QueryConfigOptionEx(*types.QueryConfigOptionEx) soap.HasFault {
+	return &methods.QueryConfigOptionExBody{
+		Res: &types.QueryConfigOptionExResponse{
+			Returnval: &types.VirtualMachineConfigOption{GuestOSDescriptor: []types.GuestOsDescriptor{b.descriptor}},
+		},
+	}
+}
+
+// moVM retrieves the test VM's current properties.
+func (e *simEnv) moVM() mo.VirtualMachine {
+	e.t.Helper()
+
+	var vm mo.VirtualMachine
+	if err := e.client.RetrieveOne(e.ctx, e.vm, nil, &vm); err != nil {
+		e.t.Fatalf("retrieving vm: %v", err)
+	}
+
+	return vm
+}
+
+// alarmEvent builds a CloudEvent body for an AlarmStatusChangedEvent.
+func alarmEvent(t *testing.T, alarm, to string, vm types.ManagedObjectReference) []byte {
+	t.Helper()
+
+	var evt cloudEvent
+	evt.Data.Alarm.Name = alarm
+	evt.Data.Alarm.Alarm = types.ManagedObjectReference{Type: "Alarm", Value: "alarm-6"}
+	evt.Data.From = "yellow"
+	evt.Data.To = to
+	if vm.Value != "" {
+		evt.Data.Vm = &types.VmEventArgument{Vm: vm}
+	}
+
+	body, err := json.Marshal(evt)
+	if err != nil {
+		t.Fatalf("marshalling event: %v", err)
+	}
+
+	return body
+}
@@ -0,0 +1,74 @@
+package function
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// actionStore keeps actions waiting to be carried out as JSON files in a
+// directory, one per action.
+type actionStore string
+
+func (s actionStore) path(id string) string {
+	return filepath.Join(string(s), id+".json")
+}
+
+func (s actionStore) save(id string, v interface{}) error {
+	if err := os.MkdirAll(string(s), 0700); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.path(id), b, 0600)
+}
+
+func (s actionStore) load(id string, v interface{}) error {
+	// IDs are hex, anything else is not ours to read.
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return errors.New("invalid action id")
+	}
+
+	b, err := ioutil.ReadFile(s.path(id))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// remove deletes an action. Only one caller succeeds, so removing an action
+// claims it.
+func (s actionStore) remove(id string) error {
+	return os.Remove(s.path(id))
+}
+
+// ids lists the stored actions.
+func (s actionStore) ids() []string {
+	files, _ := filepath.Glob(filepath.Join(string(s), "*.json"))
+
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		ids = append(ids, strings.TrimSuffix(filepath.Base(f), ".json"))
+	}
+
+	return ids
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
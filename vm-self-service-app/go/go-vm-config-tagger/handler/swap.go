@@ -0,0 +1,152 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// cardinalitySingle is the cardinality of categories whose objects have at
+// most one of their tags.
+const cardinalitySingle = "SINGLE"
+
+// swapTags makes tagID the VM's only tag in catID, attaching pendingID along
+// with it if set, and returns the tags in catID attached beforehand. Each step
+// is recorded in tx. In a MULTIPLE category the new tag is attached before the
+// old ones are detached, so the VM always has a tag in it. A SINGLE category
+// holds one tag at a time, so there the old ones are detached first. vCenter
+// refuses the new tag while the old one is attached, and has no call that
+// swaps them, so the VM briefly has no tag in the category. If the attach
+// fails, rolling back tx attaches the old tags again. Nothing is done when the
+// VM already has the right tags.
+func (clt *vsClient) swapTags(ctx context.Context, tx *transaction, catID, tagID, pendingID string, mor types.ManagedObjectReference) (attached []string, err error) {
+	ctx, span := startSpan(ctx, "tags.SwapTags", vmMoRef(mor.Value), attribute.String("vsphere.category.id", catID), attribute.String("vsphere.tag.id", tagID))
+	defer func() { endSpan(span, err) }()
+
+	cat, err := clt.tagMgr.GetCategory(ctx, catID)
+	if err != nil {
+		return nil, fmt.Errorf("getting category: %w", err)
+	}
+
+	attached, all, err := clt.categoryTags(ctx, catID, mor)
+	if err != nil {
+		return nil, fmt.Errorf("listing attached tags: %w", err)
+	}
+
+	var detach, attach []string
+	for _, id := range attached {
+		if id != tagID {
+			detach = append(detach, id)
+		}
+	}
+
+	if !containsString(attached, tagID) {
+		attach = append(attach, tagID)
+	}
+	if pendingID != "" && !containsString(all, pendingID) {
+		attach = append(attach, pendingID)
+	}
+
+	span.SetAttributes(attribute.String("vsphere.category.cardinality", cat.Cardinality), attribute.Bool("tags.unchanged", len(detach)+len(attach) == 0))
+	if len(detach)+len(attach) == 0 {
+		return attached, nil
+	}
+
+	if cat.Cardinality == cardinalitySingle {
+		if err := clt.detachEach(ctx, tx, detach, mor); err != nil {
+			return attached, err
+		}
+
+		return attached, clt.attachAll(ctx, tx, attach, mor)
+	}
+
+	if err := clt.attachAll(ctx, tx, attach, mor); err != nil {
+		return attached, err
+	}
+
+	return attached, clt.detachEach(ctx, tx, detach, mor)
+}
+
+// attachAll attaches the tags to the object in one call.
+func (clt *vsClient) attachAll(ctx context.Context, tx *transaction, tagIDs []string, mor types.ManagedObjectReference) error {
+	if len(tagIDs) == 0 {
+		return nil
+	}
+
+	err := clt.tagMgr.AttachMultipleTagsToObject(ctx, tagIDs, mor)
+
+	// A failed batch may have attached some of the tags, and only those are
+	// to be detached again.
+	attached := tagIDs
+	if err != nil {
+		_, all, lerr := clt.categoryTags(ctx, "", mor)
+		if lerr != nil {
+			return fmt.Errorf("attaching tag(s): %v; listing attached tags: %w", err, lerr)
+		}
+
+		attached = nil
+		for _, id := range tagIDs {
+			if containsString(all, id) {
+				attached = append(attached, id)
+			}
+		}
+	}
+
+	if len(attached) > 0 {
+		tx.done("attach tag(s) "+strings.Join(attached, ", "), func(ctx context.Context) error {
+			for _, id := range attached {
+				if err := clt.tagMgr.DetachTag(ctx, id, mor); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	if err != nil {
+		return fmt.Errorf("attaching tag(s): %w", err)
+	}
+
+	return nil
+}
+
+// detachEach detaches the tags from the object.
+func (clt *vsClient) detachEach(ctx context.Context, tx *transaction, tagIDs []string, mor types.ManagedObjectReference) error {
+	for _, id := range tagIDs {
+		if err := clt.tagMgr.DetachTag(ctx, id, mor); err != nil {
+			return fmt.Errorf("detaching old tag(s): %w", err)
+		}
+
+		id := id
+		tx.done("detach tag "+id, func(ctx context.Context) error {
+			return clt.tagMgr.AttachTag(ctx, id, mor)
+		})
+	}
+
+	return nil
+}
+
+// categoryTags returns the IDs of the tags in catID attached to the object,
+// and of all its tags.
+func (clt *vsClient) categoryTags(ctx context.Context, catID string, mor types.ManagedObjectReference) (inCategory, all []string, err error) {
+	attached, err := clt.tagMgr.GetAttachedTagsOnObjects(ctx, []mo.Reference{mor})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, a := range attached {
+		for _, t := range a.Tags {
+			all = append(all, t.ID)
+			if t.CategoryID == catID {
+				inCategory = append(inCategory, t.ID)
+			}
+		}
+	}
+
+	return inCategory, all, nil
+}
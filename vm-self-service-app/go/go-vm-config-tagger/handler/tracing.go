@@ -0,0 +1,110 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	tracingOnce    sync.Once
+	tracerProvider *sdktrace.TracerProvider
+)
+
+// initTracing sets up the exporter named by the otel_exporter environment
+// variable: "otlp" (configured with the standard OTEL_EXPORTER_OTLP_*
+// variables), "stdout", or none when unset. Incoming traceparent headers are
+// honoured either way.
+func initTracing() {
+	tracingOnce.Do(func() {
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			propagation.Baggage{},
+		))
+
+		exp, err := newSpanExporter(os.Getenv("otel_exporter"))
+		if err != nil {
+			baseLogger.Warn("tracing disabled", "error", err)
+			return
+		}
+
+		if exp == nil {
+			return
+		}
+
+		tracerProvider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exp),
+			sdktrace.WithResource(resource.NewWithAttributes(
+				semconv.SchemaURL,
+				semconv.ServiceName(functionName),
+			)),
+		)
+		otel.SetTracerProvider(tracerProvider)
+	})
+}
+
+func newSpanExporter(name string) (sdktrace.SpanExporter, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "otlp":
+		return otlptracehttp.New(context.Background())
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	}
+
+	return nil, fmt.Errorf("unknown otel_exporter %q, want otlp or stdout", name)
+}
+
+// flushTraces exports the spans of the invocation before the response is sent.
+func flushTraces(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+
+	if err := tracerProvider.ForceFlush(ctx); err != nil {
+		logger(ctx).Warn("flushing traces failed", "error", err)
+	}
+}
+
+// traceContext continues the trace in the request's traceparent header, if any.
+func traceContext(h http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(h))
+}
+
+// startSpan starts a span for a phase of the invocation.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a span, recording err if the phase failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Span attributes.
+func cloudEventID(id string) attribute.KeyValue {
+	return attribute.String("cloudevents.event_id", id)
+}
+
+func vmMoRef(value string) attribute.KeyValue {
+	return attribute.String("vsphere.vm.moref", value)
+}
@@ -0,0 +1,315 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	handler "github.com/openfaas/templates-sdk/go-http"
+)
+
+const defaultApprovalTimeout = time.Hour
+
+// approvalConfig is the optional [approval] section of vcconfig. When
+// enabled, changes are saved as pending and only made once approved through
+// the callback URLs sent to the webhook.
+type approvalConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Store is the directory pending actions are saved in. It must be shared
+	// by all replicas of the function.
+	Store string `toml:"store"`
+	// Webhook is the URL approval requests are POSTed to as JSON. The text
+	// field makes them readable in Slack or Teams incoming webhooks.
+	Webhook string `toml:"webhook"`
+	// CallbackURL is the URL of this function through the gateway.
+	CallbackURL string `toml:"callback_url"`
+	// Timeout is how long a request can be approved for, e.g. "30m".
+	// Defaults to 1h.
+	Timeout string `toml:"timeout"`
+}
+
+func (c approvalConfig) validate() error {
+	reqFields := map[string]string{
+		"approval store":        c.Store,
+		"approval webhook":      c.Webhook,
+		"approval callback_url": c.CallbackURL,
+	}
+
+	for k, v := range reqFields {
+		if v == "" {
+			return errors.New("required field(s) missing, including " + k)
+		}
+	}
+
+	if _, err := c.timeout(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c approvalConfig) timeout() (time.Duration, error) {
+	if c.Timeout == "" {
+		return defaultApprovalTimeout, nil
+	}
+
+	d, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("parsing approval timeout: %w", err)
+	}
+
+	return d, nil
+}
+
+// pendingAction is a change waiting for approval.
+type pendingAction struct {
+	ID      string     `json:"id"`
+	Token   string     `json:"token"`
+	Created time.Time  `json:"created"`
+	Expires time.Time  `json:"expires"`
+	Change  relocation `json:"change"`
+}
+
+// approvalRequest is the body POSTed to the approval webhook.
+type approvalRequest struct {
+	Text       string     `json:"text"`
+	ID         string     `json:"id"`
+	Function   string     `json:"function"`
+	EventID    string     `json:"event_id"`
+	Alarm      string     `json:"alarm"`
+	Action     string     `json:"action"`
+	VM         string     `json:"vm"`
+	Change     relocation `json:"change"`
+	ApproveURL string     `json:"approve_url"`
+	DenyURL    string     `json:"deny_url"`
+	Expires    time.Time  `json:"expires"`
+}
+
+// requestApproval saves the change as pending and sends the approval request.
+func requestApproval(ctx context.Context, cfg approvalConfig, change relocation) (*pendingAction, error) {
+	ctx, span := startSpan(ctx, "approval.Request", vmMoRef(change.VM.Value))
+	p, err := saveAndSendApproval(ctx, cfg, change)
+	endSpan(span, err)
+
+	return p, err
+}
+
+func saveAndSendApproval(ctx context.Context, cfg approvalConfig, change relocation) (*pendingAction, error) {
+	timeout, err := cfg.timeout()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	p := pendingAction{
+		ID:      id,
+		Token:   token,
+		Created: now,
+		Expires: now.Add(timeout),
+		Change:  change,
+	}
+
+	store := actionStore(cfg.Store)
+	expireApprovals(ctx, store)
+	if err := store.save(p.ID, p); err != nil {
+		return nil, fmt.Errorf("saving pending action: %w", err)
+	}
+
+	approveURL, denyURL := callbackURLs(cfg.CallbackURL, p)
+	body, err := json.Marshal(approvalRequest{
+		Text: fmt.Sprintf("%s wants to %s on %s (%s). Approve: %s Deny: %s Expires %s.",
+			functionName, change.Action, change.VM.Value, change.Alarm, approveURL, denyURL, p.Expires.Format(time.RFC3339)),
+		ID:         p.ID,
+		Function:   functionName,
+		EventID:    change.EventID,
+		Alarm:      change.Alarm,
+		Action:     change.Action,
+		VM:         change.VM.Value,
+		Change:     change,
+		ApproveURL: approveURL,
+		DenyURL:    denyURL,
+		Expires:    p.Expires,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := webhookSink(cfg.Webhook).write(ctx, body); err != nil {
+		store.remove(p.ID)
+		return nil, fmt.Errorf("sending approval request: %w", err)
+	}
+
+	logger(ctx).Info("approval requested", "approval", p.ID, "expires", p.Expires)
+
+	return &p, nil
+}
+
+// callbackURLs returns the URLs that approve and deny p.
+func callbackURLs(base string, p pendingAction) (string, string) {
+	link := func(decision string) string {
+		q := url.Values{}
+		q.Set("approval", p.ID)
+		q.Set("decision", decision)
+		q.Set("token", p.Token)
+
+		sep := "?"
+		if strings.Contains(base, "?") {
+			sep = "&"
+		}
+
+		return base + sep + q.Encode()
+	}
+
+	return link("approve"), link("deny")
+}
+
+// confirmPage is served for GET callbacks, so that chat link previews and
+// other prefetches can't approve an action. Its form POSTs the decision back.
+var confirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html><body>
+<p>{{.Function}}: {{.Decision}} {{.Change.Action}} on {{.Change.VM.Value}} ({{.Change.Alarm}})?</p>
+<form method="post"><button type="submit">{{.Decision}}</button></form>
+</body></html>
+`))
+
+// handleApproval carries out or discards a pending action. GET requests are
+// answered with a confirmation page, the decision is taken on POST.
+func handleApproval(req handler.Request, q url.Values) (handler.Response, error) {
+	initTracing()
+	ctx, span := startSpan(traceContext(req.Header), "Approval")
+	defer flushTraces(ctx)
+	defer span.End()
+
+	id, decision := q.Get("approval"), q.Get("decision")
+	ctx = withLogger(ctx, baseLogger.With("approval", id, "decision", decision))
+
+	if decision != "approve" && decision != "deny" {
+		return respond(ctx, http.StatusBadRequest, "decision must be approve or deny")
+	}
+
+	cfg, err := loadTomlCfg(secretPath("vcconfig"))
+	if err != nil {
+		return errRespondAndLog(ctx, fmt.Errorf("loading of vcconfig: %w", err))
+	}
+
+	var p pendingAction
+	store := actionStore(cfg.Approval.Store)
+	err = store.load(id, &p)
+	if err != nil || subtle.ConstantTimeCompare([]byte(p.Token), []byte(q.Get("token"))) != 1 {
+		return respond(ctx, http.StatusNotFound, "No such pending action.")
+	}
+
+	change := p.Change
+	span.SetAttributes(cloudEventID(change.EventID), vmMoRef(change.VM.Value))
+	ctx = withLogger(ctx, logger(ctx).With("event_id", change.EventID, "vm", change.VM.Value, "vcenter", cfg.VCenter.Server))
+
+	if req.Method != http.MethodPost {
+		var buf bytes.Buffer
+		err := confirmPage.Execute(&buf, struct {
+			Function string
+			Decision string
+			Change   relocation
+		}{functionName, decision, change})
+		if err != nil {
+			return errRespondAndLog(ctx, err)
+		}
+
+		return handler.Response{
+			Body:       buf.Bytes(),
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		}, nil
+	}
+
+	// Removing the pending action claims it, so it is carried out at most once.
+	if err := store.remove(id); err != nil {
+		return respond(ctx, http.StatusNotFound, "No such pending action.")
+	}
+
+	if time.Now().After(p.Expires) {
+		countEvent(change.Alarm, change.Action, outcomeExpired)
+		return respond(ctx, http.StatusGone, "Approval expired, nothing done.")
+	}
+
+	if decision == "deny" {
+		countEvent(change.Alarm, change.Action, outcomeDenied)
+		return respond(ctx, http.StatusOK, "Denied, nothing done.")
+	}
+
+	start := time.Now()
+	loginCtx, loginSpan := startSpan(ctx, "vcenter.login")
+	vsClt, err := newClient(loginCtx, cfg)
+	endSpan(loginSpan, err)
+	if err != nil {
+		return failed(ctx, change.Alarm, change.Action, fmt.Errorf("connecting to vSphere: %w", err))
+	}
+	observeSince(loginDuration, start)
+
+	// The VM may have changed while the relocation waited for approval.
+	err = change.recheck(ctx, cfg, vsClt)
+	var stale *staleError
+	if errors.As(err, &stale) {
+		change.refuse(ctx, cfg, vsClt, stale.reason)
+		countEvent(change.Alarm, change.Action, stale.outcome)
+		return respond(ctx, http.StatusConflict, fmt.Sprintf("Approved, but not relocating %s, %s.", change.VM.Value, stale.reason))
+	}
+	if err != nil {
+		return failed(ctx, change.Alarm, change.Action, fmt.Errorf("rechecking relocation: %w", err))
+	}
+
+	_, message, outcome, err := carryOut(ctx, cfg, vsClt, change)
+	if err != nil {
+		return failed(ctx, change.Alarm, change.Action, err)
+	}
+	countEvent(change.Alarm, change.Action, outcome)
+
+	code := http.StatusOK
+	if outcome == outcomeBlocked {
+		code = http.StatusConflict
+	}
+
+	return respond(ctx, code, "Approved. "+message)
+}
+
+// respond logs message and sends it with status code.
+func respond(ctx context.Context, code int, message string) (handler.Response, error) {
+	logger(ctx).Info(message, "status", code)
+
+	return handler.Response{
+		Body:       []byte(message),
+		StatusCode: code,
+	}, nil
+}
+
+// expireApprovals removes pending actions that can no longer be approved.
+func expireApprovals(ctx context.Context, store actionStore) {
+	for _, id := range store.ids() {
+		var p pendingAction
+		if err := store.load(id, &p); err != nil || time.Now().Before(p.Expires) {
+			continue
+		}
+
+		if store.remove(id) == nil {
+			logger(ctx).Info("approval expired", "approval", id, "event_id", p.Change.EventID, "vm", p.Change.VM.Value)
+			countEvent(p.Change.Alarm, p.Change.Action, outcomeExpired)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Which of the VM's disks are moved.
+const (
+	disksAll     = "all"
+	disksAlarmed = "alarmed"
+	disksLargest = "largest"
+)
+
+// Formats moved disks can be converted to.
+const (
+	formatThin             = "thin"
+	formatThick            = "thick"
+	formatEagerZeroedThick = "eager_zeroed_thick"
+)
+
+// placementConfig is the optional [placement] section of vcconfig. It decides
+// which of the VM's disks are moved, and how.
+type placementConfig struct {
+	// Disks is "all" to move the whole VM, "alarmed" to move only the disks
+	// on the alarmed datastore, or "largest" to move only the largest disks.
+	// Defaults to all.
+	Disks string `toml:"disks"`
+	// Largest is how many disks "largest" moves. Defaults to 1.
+	Largest int `toml:"largest"`
+	// DiskFormat converts moved disks to "thin", "thick" or
+	// "eager_zeroed_thick". Without one, disks keep their format.
+	DiskFormat string `toml:"disk_format"`
+	// StorageProfile is the ID of a VM storage policy given to moved disks.
+	StorageProfile string `toml:"storage_profile"`
+	// CheckStoragePolicy only moves to datastores compatible with the storage
+	// policies of what moves.
+	CheckStoragePolicy bool `toml:"check_storage_policy"`
+}
+
+func (c placementConfig) validate() error {
+	switch c.Disks {
+	case "", disksAll, disksAlarmed, disksLargest:
+	default:
+		return fmt.Errorf("placement disks must be %s, %s or %s", disksAll, disksAlarmed, disksLargest)
+	}
+
+	switch c.DiskFormat {
+	case "", formatThin, formatThick, formatEagerZeroedThick:
+	default:
+		return fmt.Errorf("placement disk_format must be %s, %s or %s", formatThin, formatThick, formatEagerZeroedThick)
+	}
+
+	if c.Largest < 0 {
+		return errors.New("placement largest must not be negative")
+	}
+
+	return nil
+}
+
+// perDisk reports whether disks are moved one by one rather than with the
+// VM.
+func (c placementConfig) perDisk() bool {
+	return (c.Disks != "" && c.Disks != disksAll) || c.DiskFormat != "" || c.StorageProfile != ""
+}
+
+// diskMove is the move of one of the VM's disks.
+type diskMove struct {
+	Key       int32                        `json:"key"`
+	Label     string                       `json:"label"`
+	SizeBytes int64                        `json:"size_bytes"`
+	Mode      string                       `json:"mode"`
+	Datastore types.ManagedObjectReference `json:"datastore"`
+	Format    string                       `json:"format,omitempty"`
+	Profile   string                       `json:"profile,omitempty"`
+}
+
+// locator returns the disk locator of the move.
+func (d diskMove) locator() types.VirtualMachineRelocateSpecDiskLocator {
+	l := types.VirtualMachineRelocateSpecDiskLocator{
+		DiskId:       d.Key,
+		Datastore:    d.Datastore,
+		DiskMoveType: diskMoveType,
+	}
+
+	if d.Format != "" {
+		l.DiskBackingInfo = &types.VirtualDiskFlatVer2BackingInfo{
+			VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{Datastore: &d.Datastore},
+			DiskMode:                     d.Mode,
+			ThinProvisioned:              types.NewBool(d.Format == formatThin),
+			EagerlyScrub:                 types.NewBool(d.Format == formatEagerZeroedThick),
+		}
+	}
+
+	if d.Profile != "" {
+		l.Profile = []types.BaseVirtualMachineProfileSpec{
+			&types.VirtualMachineDefinedProfileSpec{ProfileId: d.Profile},
+		}
+	}
+
+	return l
+}
+
+// vmDisk is a virtual disk of the VM and the datastore it is on.
+type vmDisk struct {
+	disk      *types.VirtualDisk
+	label     string
+	datastore types.ManagedObjectReference
+	mode      string
+}
+
+// vmDisks returns the VM's virtual disks.
+func vmDisks(moVM mo.VirtualMachine) []vmDisk {
+	if moVM.Config == nil {
+		return nil
+	}
+
+	var disks []vmDisk
+	for _, dev := range moVM.Config.Hardware.Device {
+		disk, ok := dev.(*types.VirtualDisk)
+		if !ok {
+			continue
+		}
+
+		d := vmDisk{disk: disk, mode: string(types.VirtualDiskModePersistent)}
+
+		if disk.DeviceInfo != nil {
+			d.label = disk.DeviceInfo.GetDescription().Label
+		}
+
+		if b, ok := disk.Backing.(types.BaseVirtualDeviceFileBackingInfo); ok {
+			if ds := b.GetVirtualDeviceFileBackingInfo().Datastore; ds != nil {
+				d.datastore = *ds
+			}
+		}
+
+		switch b := disk.Backing.(type) {
+		case *types.VirtualDiskFlatVer2BackingInfo:
+			d.mode = b.DiskMode
+		case *types.VirtualDiskSeSparseBackingInfo:
+			d.mode = b.DiskMode
+		case *types.VirtualDiskSparseVer2BackingInfo:
+			d.mode = b.DiskMode
+		case *types.VirtualDiskRawDiskMappingVer1BackingInfo:
+			d.mode = b.DiskMode
+		}
+
+		disks = append(disks, d)
+	}
+
+	return disks
+}
+
+// selectDisks returns the disks to move, largest first for "largest".
+func (c placementConfig) selectDisks(disks []vmDisk, alarmed types.ManagedObjectReference) []vmDisk {
+	var selected []vmDisk
+
+	switch c.Disks {
+	case disksAlarmed:
+		for _, d := range disks {
+			if d.datastore == alarmed {
+				selected = append(selected, d)
+			}
+		}
+	case disksLargest:
+		selected = append(selected, disks...)
+		sort.SliceStable(selected, func(i, j int) bool {
+			return selected[i].disk.CapacityInBytes > selected[j].disk.CapacityInBytes
+		})
+
+		n := c.Largest
+		if n == 0 {
+			n = 1
+		}
+		if n < len(selected) {
+			selected = selected[:n]
+		}
+	default:
+		selected = disks
+	}
+
+	return selected
+}
+
+// fullestDatastore returns the datastore with the least free space for its
+// capacity.
+func fullestDatastore(dss []mo.Datastore) types.ManagedObjectReference {
+	var (
+		fullest types.ManagedObjectReference
+		least   = 2.0
+	)
+
+	for _, ds := range dss {
+		s := ds.Summary
+		if s.Capacity <= 0 {
+			continue
+		}
+
+		if free := float64(s.FreeSpace) / float64(s.Capacity); free < least {
+			fullest, least = ds.Self, free
+		}
+	}
+
+	return fullest
+}
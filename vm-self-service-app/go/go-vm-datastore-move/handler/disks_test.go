@@ -0,0 +1,84 @@
+package function
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestSelectDisks(t *testing.T) {
+	ds1 := types.ManagedObjectReference{Type: "Datastore", Value: "ds-1"}
+	ds2 := types.ManagedObjectReference{Type: "Datastore", Value: "ds-2"}
+
+	disk := func(key int32, size int64, ds types.ManagedObjectReference) vmDisk {
+		d := &types.VirtualDisk{CapacityInBytes: size}
+		d.Key = key
+
+		return vmDisk{disk: d, datastore: ds}
+	}
+	disks := []vmDisk{disk(2000, 10, ds1), disk(2001, 30, ds2), disk(2002, 20, ds1)}
+
+	tests := []struct {
+		name string
+		cfg  placementConfig
+		want []int32
+	}{
+		{"all", placementConfig{}, []int32{2000, 2001, 2002}},
+		{"alarmed", placementConfig{Disks: disksAlarmed}, []int32{2000, 2002}},
+		{"largest", placementConfig{Disks: disksLargest}, []int32{2001}},
+		{"two largest", placementConfig{Disks: disksLargest, Largest: 2}, []int32{2001, 2002}},
+		{"more largest than disks", placementConfig{Disks: disksLargest, Largest: 5}, []int32{2001, 2002, 2000}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []int32
+			for _, d := range tc.cfg.selectDisks(disks, ds1) {
+				got = append(got, d.disk.Key)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("selectDisks() = %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("selectDisks() = %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDiskMoveLocator(t *testing.T) {
+	ds := types.ManagedObjectReference{Type: "Datastore", Value: "ds-2"}
+
+	l := diskMove{Key: 2000, Mode: "persistent", Datastore: ds}.locator()
+	if l.DiskId != 2000 || l.Datastore != ds || l.DiskBackingInfo != nil || l.Profile != nil {
+		t.Errorf("locator() = %+v, want disk 2000 on ds-2 as is", l)
+	}
+
+	l = diskMove{Key: 2000, Mode: "independent_persistent", Datastore: ds, Format: formatThin, Profile: "p-1"}.locator()
+	b, ok := l.DiskBackingInfo.(*types.VirtualDiskFlatVer2BackingInfo)
+	if !ok || !*b.ThinProvisioned || *b.EagerlyScrub || b.DiskMode != "independent_persistent" {
+		t.Errorf("locator() backing = %+v, want thin independent_persistent", l.DiskBackingInfo)
+	}
+
+	if len(l.Profile) != 1 || l.Profile[0].(*types.VirtualMachineDefinedProfileSpec).ProfileId != "p-1" {
+		t.Errorf("locator() profile = %+v, want p-1", l.Profile)
+	}
+}
+
+func TestFullestDatastore(t *testing.T) {
+	ds := func(name string, free, capacity int64) mo.Datastore {
+		d := mo.Datastore{Summary: types.DatastoreSummary{FreeSpace: free, Capacity: capacity}}
+		d.Self = types.ManagedObjectReference{Type: "Datastore", Value: name}
+
+		return d
+	}
+
+	got := fullestDatastore([]mo.Datastore{ds("ds-1", 50, 100), ds("ds-2", 100, 1000), ds("ds-3", 0, 0)})
+	if got.Value != "ds-2" {
+		t.Errorf("fullestDatastore() = %v, want ds-2", got)
+	}
+}
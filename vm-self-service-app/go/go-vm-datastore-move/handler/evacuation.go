@@ -0,0 +1,331 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	handler "github.com/openfaas/templates-sdk/go-http"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// datastoreAlarm is the datastore alarm that evacuates VMs off the datastore.
+const datastoreAlarm = "Datastore usage on disk"
+
+// Evacuation defaults.
+const (
+	defaultTargetUsage = 0.8
+	defaultConcurrency = 2
+	defaultMaxVMs      = 10
+)
+
+// evacuationConfig is the optional [evacuation] section of vcconfig. It
+// decides how many VMs are moved off a datastore in alarm, and how.
+type evacuationConfig struct {
+	// TargetUsage is the fraction of the datastore's capacity in use to
+	// bring it below, e.g. 0.75. Defaults to 0.8.
+	TargetUsage float64 `toml:"target_usage"`
+	// Concurrency is how many relocations run at once. Defaults to 2.
+	Concurrency int `toml:"concurrency"`
+	// MaxVMs is the most VMs moved for one alarm. Defaults to 10.
+	MaxVMs int `toml:"max_vms"`
+}
+
+func (c evacuationConfig) validate() error {
+	if c.TargetUsage < 0 || c.TargetUsage >= 1 {
+		return errors.New("evacuation target_usage must be from 0 up to 1")
+	}
+
+	if c.Concurrency < 0 || c.MaxVMs < 0 {
+		return errors.New("evacuation concurrency and max_vms must not be negative")
+	}
+
+	return nil
+}
+
+func (c evacuationConfig) targetUsage() float64 {
+	if c.TargetUsage == 0 {
+		return defaultTargetUsage
+	}
+
+	return c.TargetUsage
+}
+
+func (c evacuationConfig) concurrency() int {
+	if c.Concurrency == 0 {
+		return defaultConcurrency
+	}
+
+	return c.Concurrency
+}
+
+func (c evacuationConfig) maxVMs() int {
+	if c.MaxVMs == 0 {
+		return defaultMaxVMs
+	}
+
+	return c.MaxVMs
+}
+
+func isDatastoreInAlarm(event cloudEvent) bool {
+	return event.Data.Alarm.Name == datastoreAlarm && event.Data.To == "red" && event.Data.Ds != nil
+}
+
+// handleDatastoreAlarm evacuates VMs off a datastore in alarm, until its usage
+// is below the target. The relocations run in the background.
+func handleDatastoreAlarm(ctx context.Context, cloudEvt cloudEvent, action string) (handler.Response, error) {
+	alarm := cloudEvt.Data.Alarm.Name
+
+	if !isDatastoreInAlarm(cloudEvt) {
+		message := "Storage not in red alert, nothing to do."
+		logger(ctx).Info(message, "alarm", alarm)
+		countEvent(alarm, action, outcomeIgnored)
+
+		return handler.Response{
+			Body:       []byte(message),
+			StatusCode: http.StatusOK,
+		}, nil
+	}
+
+	ds := cloudEvt.Data.Ds.Datastore
+
+	// Load config every time, to ensure the most updated version is used.
+	cfg, err := loadTomlCfg(secretPath("vcconfig"))
+	if err != nil {
+		return failed(ctx, alarm, action, fmt.Errorf("loading of vcconfig: %w", err))
+	}
+	ctx = withLogger(ctx, logger(ctx).With("vcenter", cfg.VCenter.Server))
+
+	start := time.Now()
+	loginCtx, loginSpan := startSpan(ctx, "vcenter.login")
+	vsClt, err := newClient(loginCtx, cfg)
+	endSpan(loginSpan, err)
+	if err != nil {
+		return failed(ctx, alarm, action, fmt.Errorf("connecting to vSphere: %w", err))
+	}
+	observeSince(loginDuration, start)
+
+	changes, freed, need, err := vsClt.planEvacuation(ctx, cfg, cloudEvt, action)
+	if err != nil {
+		return failed(ctx, alarm, action, fmt.Errorf("planning evacuation: %w", err))
+	}
+
+	target := cfg.Evacuation.targetUsage() * 100
+
+	if need <= 0 || len(changes) == 0 {
+		message := fmt.Sprintf("Not evacuating %s, its usage is below %.0f%%.", ds.Value, target)
+		outcome := outcomeIgnored
+		if need > 0 {
+			message = fmt.Sprintf("Not evacuating %s, no vm on it can be moved.", ds.Value)
+			outcome = outcomeNoPlacement
+		}
+
+		logger(ctx).Info(message, "alarm", alarm, "action", action)
+		countEvent(alarm, action, outcome)
+
+		return handler.Response{
+			Body:       []byte(message),
+			StatusCode: http.StatusOK,
+		}, nil
+	}
+
+	reason := fmt.Sprintf("freeing %d bytes to bring its usage below %.0f%%", freed, target)
+	if freed < need {
+		reason = fmt.Sprintf("freeing %d of the %d bytes needed to bring its usage below %.0f%%", freed, need, target)
+	}
+
+	vms := make([]string, 0, len(changes))
+	for _, c := range changes {
+		vms = append(vms, c.VM.Value)
+	}
+
+	if cfg.Approval.Enabled {
+		for _, change := range changes {
+			if _, err := requestApproval(ctx, cfg.Approval, change); err != nil {
+				return failed(ctx, alarm, action, fmt.Errorf("requesting approval: %w", err))
+			}
+			countEvent(alarm, action, outcomePending)
+		}
+
+		message := fmt.Sprintf("Approvals requested to evacuate %s from %s, %s.", strings.Join(vms, ", "), ds.Value, reason)
+		logger(ctx).Info(message, "alarm", alarm, "action", action)
+
+		return handler.Response{
+			Body:       []byte(message),
+			StatusCode: http.StatusOK,
+		}, nil
+	}
+
+	go evacuate(ctx, cfg, vsClt, changes)
+
+	message := fmt.Sprintf("Evacuating %s from %s, %s.", strings.Join(vms, ", "), ds.Value, reason)
+	logger(ctx).Info(message, "alarm", alarm, "action", action)
+
+	return handler.Response{
+		Body:       []byte(message),
+		StatusCode: http.StatusOK,
+	}, nil
+}
+
+// evacuee is a VM on the datastore in alarm, and how much of it is stored
+// there.
+type evacuee struct {
+	vm    types.ManagedObjectReference
+	bytes int64
+}
+
+// planEvacuation returns the relocations that bring the datastore's usage
+// below the target, the bytes they free and the bytes that need freeing. It
+// moves as few VMs as it can by moving the largest first. VMs that opted out
+// of automation, or have nowhere to go, are passed over.
+func (clt *vsClient) planEvacuation(ctx context.Context, cfg *vcConfig, cloudEvt cloudEvent, action string) (changes []relocation, freed, need int64, err error) {
+	ds := cloudEvt.Data.Ds.Datastore
+
+	ctx, span := startSpan(ctx, "evacuation.Plan", attribute.String("vsphere.datastore.moref", ds.Value))
+	defer func() { endSpan(span, err) }()
+
+	pc := property.DefaultCollector(clt.govmomi.Client)
+
+	var moDS mo.Datastore
+	if err := timedRetrieve(func() error {
+		return pc.RetrieveOne(ctx, ds, []string{"summary", "vm"}, &moDS)
+	}); err != nil {
+		return nil, 0, 0, fmt.Errorf("retrieving datastore: %w", err)
+	}
+
+	capacity := moDS.Summary.Capacity
+	need = capacity - moDS.Summary.FreeSpace - int64(cfg.Evacuation.targetUsage()*float64(capacity))
+	if need <= 0 {
+		return nil, 0, need, nil
+	}
+
+	var moVMs []mo.VirtualMachine
+	if len(moDS.Vm) > 0 {
+		err := timedRetrieve(func() error {
+			return pc.Retrieve(ctx, moDS.Vm, []string{"storage.perDatastoreUsage", "summary.storage", "config.template"}, &moVMs)
+		})
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("retrieving vms of datastore: %w", err)
+		}
+	}
+
+	for _, e := range evacuationOrder(moVMs, ds) {
+		if freed >= need || len(changes) >= cfg.Evacuation.maxVMs() {
+			break
+		}
+
+		vctx := withLogger(ctx, logger(ctx).With("vm", e.vm.Value))
+
+		if cfg.Automation.enabled() {
+			allowed, why, err := clt.automationAllowed(vctx, cfg.Automation, e.vm)
+			if err != nil {
+				return nil, 0, 0, fmt.Errorf("checking automation setting of %s: %w", e.vm.Value, err)
+			}
+
+			if !allowed {
+				logger(vctx).Info("not evacuating vm", "reason", why)
+				continue
+			}
+		}
+
+		plan, err := relocSpec(clt, vctx, cfg.Placement, e.vm, &ds)
+		if errors.Is(err, errNoPlacement) {
+			logger(vctx).Info("not evacuating vm", "reason", err.Error())
+			continue
+		}
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("planning relocation of %s: %w", e.vm.Value, err)
+		}
+
+		changes = append(changes, relocation{
+			EventID:   cloudEvt.ID,
+			Alarm:     cloudEvt.Data.Alarm.Name,
+			Action:    action,
+			VM:        e.vm,
+			placement: plan,
+		})
+		freed += e.bytes
+	}
+
+	return changes, freed, need, nil
+}
+
+// evacuationOrder returns the VMs that aren't templates, by how much of them
+// is stored on the datastore, largest first.
+func evacuationOrder(moVMs []mo.VirtualMachine, ds types.ManagedObjectReference) []evacuee {
+	var order []evacuee
+	for _, vm := range moVMs {
+		if vm.Config != nil && vm.Config.Template {
+			continue
+		}
+
+		order = append(order, evacuee{vm: vm.Self, bytes: usageOn(vm, ds)})
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].bytes > order[j].bytes
+	})
+
+	return order
+}
+
+// usageOn returns the bytes of the VM stored on the datastore, or of the
+// whole VM if the datastore's share isn't known.
+func usageOn(vm mo.VirtualMachine, ds types.ManagedObjectReference) int64 {
+	if vm.Storage != nil {
+		for _, u := range vm.Storage.PerDatastoreUsage {
+			if u.Datastore == ds {
+				return u.Committed
+			}
+		}
+	}
+
+	if vm.Summary.Storage != nil {
+		return vm.Summary.Storage.Committed
+	}
+
+	return 0
+}
+
+// evacuate carries out the relocations, at most concurrency at a time. A
+// relocation counts until its task finishes.
+func evacuate(ctx context.Context, cfg *vcConfig, clt *vsClient, changes []relocation) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, cfg.Evacuation.concurrency())
+
+	for _, change := range changes {
+		slots <- struct{}{}
+		wg.Add(1)
+
+		go func(change relocation) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			vctx := withLogger(ctx, logger(ctx).With("vm", change.VM.Value))
+			task, message, outcome, err := carryOut(vctx, cfg, clt, change)
+			if err != nil {
+				logger(vctx).Error("evacuating vm", "error", err)
+				countEvent(change.Alarm, change.Action, outcomeFailed)
+				return
+			}
+
+			logger(vctx).Info(message, "alarm", change.Alarm, "action", change.Action)
+			countEvent(change.Alarm, change.Action, outcome)
+
+			// Failed tasks are logged and counted as they are observed.
+			if task != nil {
+				_ = task.Wait(vctx)
+			}
+		}(change)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,71 @@
+package function
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestEvacuationOrder(t *testing.T) {
+	ds1 := types.ManagedObjectReference{Type: "Datastore", Value: "ds-1"}
+	ds2 := types.ManagedObjectReference{Type: "Datastore", Value: "ds-2"}
+
+	vm := func(id string, usage map[types.ManagedObjectReference]int64, committed int64) mo.VirtualMachine {
+		moVM := mo.VirtualMachine{
+			Storage: &types.VirtualMachineStorageInfo{},
+			Summary: types.VirtualMachineSummary{Storage: &types.VirtualMachineStorageSummary{Committed: committed}},
+		}
+		moVM.Self = types.ManagedObjectReference{Type: "VirtualMachine", Value: id}
+
+		for ds, n := range usage {
+			moVM.Storage.PerDatastoreUsage = append(moVM.Storage.PerDatastoreUsage, types.VirtualMachineUsageOnDatastore{Datastore: ds, Committed: n})
+		}
+
+		return moVM
+	}
+
+	template := vm("vm-4", map[types.ManagedObjectReference]int64{ds1: 90}, 90)
+	template.Config = &types.VirtualMachineConfigInfo{Template: true}
+
+	vms := []mo.VirtualMachine{
+		vm("vm-1", map[types.ManagedObjectReference]int64{ds1: 10, ds2: 80}, 90),
+		// Without per datastore usage, the whole VM counts.
+		vm("vm-2", nil, 30),
+		vm("vm-3", map[types.ManagedObjectReference]int64{ds1: 50}, 50),
+		template,
+	}
+
+	got := evacuationOrder(vms, ds1)
+
+	want := []evacuee{
+		{vm: vms[2].Self, bytes: 50},
+		{vm: vms[1].Self, bytes: 30},
+		{vm: vms[0].Self, bytes: 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("evacuationOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestEvacuationConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     evacuationConfig
+		wantErr bool
+	}{
+		{"defaults", evacuationConfig{}, false},
+		{"set", evacuationConfig{TargetUsage: 0.75, Concurrency: 4, MaxVMs: 20}, false},
+		{"full target", evacuationConfig{TargetUsage: 1}, true},
+		{"negative concurrency", evacuationConfig{Concurrency: -1}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.cfg.validate(); (err != nil) != tc.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -1,47 +1,96 @@
 package function
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	handler "github.com/openfaas/templates-sdk/go-http"
 	"github.com/pelletier/go-toml"
 	"github.com/vmware/govmomi"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/pbm"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const secretPath = "/var/openfaas/secrets/vcconfig"
+// secretMountPath is where OpenFaaS mounts secrets, unless overridden by the
+// secret_mount_path environment variable (e.g. when running locally).
+const secretMountPath = "/var/openfaas/secrets"
+
+// functionName names the function in its logs, traces, audit records and
+// notifications, and tracerName its spans.
+const (
+	functionName = "vm-datastore-placement-fn"
+	tracerName   = "github.com/pksrc/vebafn/vm-self-service-app/go/go-vm-datastore-move"
+)
+
+//go:generate sh ../../sync-shared.sh
+
+// relocSpec plans the relocation of the VM in alarm.
+var relocSpec = (*vsClient).planRelocation
 
 // Handle a function invocation
 func Handle(req handler.Request) (handler.Response, error) {
-	ctx := context.Background()
+	q, _ := url.ParseQuery(req.QueryString)
+	if q.Get("approval") != "" {
+		return handleApproval(req, q)
+	}
 
-	// Load config every time, to ensure the most updated version is used.
-	cfg, err := loadTomlCfg(secretPath)
-	if err != nil {
-		return errRespondAndLog(fmt.Errorf("loading of vcconfig: %w", err))
+	if req.Method == http.MethodGet {
+		return metricsResponse()
 	}
 
-	vsClt, err := newClient(ctx, cfg)
-	if err != nil {
-		return errRespondAndLog(fmt.Errorf("connecting to vSphere: %w", err))
+	if len(bytes.TrimSpace(req.Body)) == 0 {
+		return runQueue(req)
 	}
+	defer pushMetrics()
+
+	initTracing()
+	ctx, span := startSpan(traceContext(req.Header), "Handle")
+	defer flushTraces(ctx)
+	defer span.End()
+	ctx = withLogger(ctx, baseLogger)
 
 	cloudEvt, err := parseCloudEvent(req.Body)
 	if err != nil {
-		return errRespondAndLog(fmt.Errorf("parsing cloud event data: %w", err))
+		return failed(ctx, "", actionNone, fmt.Errorf("parsing cloud event data: %w", err))
 	}
 
+	alarm := cloudEvt.Data.Alarm.Name
+	action := alarmAction(alarm)
+	countEvent(alarm, action, outcomeReceived)
+
+	// Datastore alarms have a datastore rather than a VM.
+	if cloudEvt.Data.Vm == nil {
+		span.SetAttributes(cloudEventID(cloudEvt.ID))
+		ctx = withLogger(ctx, logger(ctx).With("event_id", cloudEvt.ID, "datastore", cloudEvt.Data.Ds.Datastore.Value))
+		logger(ctx).Debug("event received", "alarm", alarm, "from", cloudEvt.Data.From, "to", cloudEvt.Data.To)
+
+		return handleDatastoreAlarm(ctx, cloudEvt, action)
+	}
+
+	span.SetAttributes(cloudEventID(cloudEvt.ID), vmMoRef(cloudEvt.Data.Vm.Vm.Value))
+	ctx = withLogger(ctx, logger(ctx).With("event_id", cloudEvt.ID, "vm", cloudEvt.Data.Vm.Vm.Value))
+	logger(ctx).Debug("event received", "alarm", alarm, "from", cloudEvt.Data.From, "to", cloudEvt.Data.To)
+
 	// Determine if data AlarmStatusChangedEvent is correct.
 	if !isStorageInAlarm(cloudEvt) {
 		message := "Storage not in red alert, nothing to do."
-		log.Println(message)
+		logger(ctx).Info(message, "alarm", alarm)
+		countEvent(alarm, action, outcomeIgnored)
 
 		return handler.Response{
 			Body:       []byte(message),
@@ -49,34 +98,123 @@ func Handle(req handler.Request) (handler.Response, error) {
 		}, nil
 	}
 
+	// Load config every time, to ensure the most updated version is used.
+	cfg, err := loadTomlCfg(secretPath("vcconfig"))
+	if err != nil {
+		return failed(ctx, alarm, action, fmt.Errorf("loading of vcconfig: %w", err))
+	}
+	ctx = withLogger(ctx, logger(ctx).With("vcenter", cfg.VCenter.Server))
+
+	start := time.Now()
+	loginCtx, loginSpan := startSpan(ctx, "vcenter.login")
+	vsClt, err := newClient(loginCtx, cfg)
+	endSpan(loginSpan, err)
+	if err != nil {
+		return failed(ctx, alarm, action, fmt.Errorf("connecting to vSphere: %w", err))
+	}
+	observeSince(loginDuration, start)
+
 	// The Mananged Object Reference for the VM that caused storage alarm.
 	vmMOR, err := eventVmMoRef(cloudEvt)
 	if err != nil {
-		return errRespondAndLog(fmt.Errorf("retrieving VM object: %w", err))
+		return failed(ctx, alarm, action, fmt.Errorf("retrieving VM object: %w", err))
 	}
 
-	vm := object.NewVirtualMachine(vsClt.govmomi.Client, vmMOR)
+	if cfg.Automation.enabled() {
+		allowed, reason, err := vsClt.automationAllowed(ctx, cfg.Automation, vmMOR)
+		if err != nil {
+			return failed(ctx, alarm, action, fmt.Errorf("checking automation setting: %w", err))
+		}
 
-	// TODO: Determine relocation spec without hardcoding.
-	spec := generateRelocSpec()
+		if !allowed {
+			message := fmt.Sprintf("Not remediating %s, %s.", vmMOR.Value, reason)
+			logger(ctx).Info(message, "alarm", alarm, "action", action)
+			countEvent(alarm, action, outcomeOptedOut)
 
-	// Relocate the VM onto a different datastore.
-	task, err := vm.Relocate(ctx, spec, types.VirtualMachineMovePriorityHighPriority)
+			return handler.Response{
+				Body:       []byte(message),
+				StatusCode: http.StatusOK,
+			}, nil
+		}
+	}
+
+	var alarmed *types.ManagedObjectReference
+	if cloudEvt.Data.Ds != nil {
+		alarmed = &cloudEvt.Data.Ds.Datastore
+	}
+
+	plan, err := relocSpec(vsClt, ctx, cfg.Placement, vmMOR, alarmed)
+	if errors.Is(err, errNoPlacement) {
+		message := fmt.Sprintf("Not relocating %s, %s.", vmMOR.Value, err)
+		logger(ctx).Info(message, "alarm", alarm, "action", action)
+		countEvent(alarm, action, outcomeNoPlacement)
+
+		return handler.Response{
+			Body:       []byte(message),
+			StatusCode: http.StatusOK,
+		}, nil
+	}
 	if err != nil {
-		return errRespondAndLog(fmt.Errorf("connecting to vSphere: %w", err))
+		return failed(ctx, alarm, action, fmt.Errorf("planning relocation: %w", err))
 	}
 
-	message := relocatedMessage(task)
-	log.Println(message)
+	change := relocation{
+		EventID:   cloudEvt.ID,
+		Alarm:     alarm,
+		Action:    action,
+		VM:        vmMOR,
+		placement: plan,
+	}
+
+	if cfg.Approval.Enabled {
+		p, err := requestApproval(ctx, cfg.Approval, change)
+		if err != nil {
+			return failed(ctx, alarm, action, fmt.Errorf("requesting approval: %w", err))
+		}
+
+		message := fmt.Sprintf("Approval %s requested to relocate %s.", p.ID, vmMOR.Value)
+		logger(ctx).Info(message, "alarm", alarm, "action", action)
+		countEvent(alarm, action, outcomePending)
+
+		return handler.Response{
+			Body:       []byte(message),
+			StatusCode: http.StatusOK,
+		}, nil
+	}
+
+	_, message, outcome, err := carryOut(ctx, cfg, vsClt, change)
+	if err != nil {
+		return failed(ctx, alarm, action, err)
+	}
+
+	logger(ctx).Info(message, "alarm", alarm, "action", action)
+	countEvent(alarm, action, outcome)
+
+	code := http.StatusOK
+	if outcome == outcomeBlocked {
+		code = http.StatusConflict
+	}
 
 	return handler.Response{
 		Body:       []byte(message),
-		StatusCode: http.StatusOK,
+		StatusCode: code,
 	}, nil
 }
 
-func errRespondAndLog(err error) (handler.Response, error) {
-	log.Println(err.Error())
+// failed counts a failed event and marks the invocation's span as failed
+// before responding with the error.
+func failed(ctx context.Context, alarm, action string, err error) (handler.Response, error) {
+	countEvent(alarm, action, outcomeFailed)
+
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	return errRespondAndLog(ctx, err)
+}
+
+func errRespondAndLog(ctx context.Context, err error) (handler.Response, error) {
+	logger(ctx).Error("invocation failed", "error", err)
 
 	return handler.Response{
 		Body:       []byte(err.Error()),
@@ -84,6 +222,16 @@ func errRespondAndLog(err error) (handler.Response, error) {
 	}, err
 }
 
+// secretPath returns the path of the named secret.
+func secretPath(name string) string {
+	dir := os.Getenv("secret_mount_path")
+	if dir == "" {
+		dir = secretMountPath
+	}
+
+	return filepath.Join(dir, name)
+}
+
 func loadTomlCfg(path string) (*vcConfig, error) {
 	var cfg vcConfig
 
@@ -96,6 +244,7 @@ func loadTomlCfg(path string) (*vcConfig, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshal vcconfig.toml: %w", err)
 	}
+	redactSecret(cfg.VCenter.Password)
 
 	err = validateConfig(cfg)
 	if err != nil {
@@ -120,6 +269,34 @@ func validateConfig(cfg vcConfig) error {
 		}
 	}
 
+	if cfg.Approval.Enabled {
+		if err := cfg.Approval.validate(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Schedule.enabled() {
+		if err := cfg.Schedule.validate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := cfg.Limits.window(); err != nil {
+		return err
+	}
+
+	if err := cfg.Placement.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Evacuation.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Preflight.validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -143,6 +320,21 @@ func newClient(ctx context.Context, cfg *vcConfig) (*vsClient, error) {
 		govmomi: gc,
 	}
 
+	if cfg.Placement.CheckStoragePolicy {
+		if vsc.pbm, err = pbm.NewClient(ctx, gc.Client); err != nil {
+			return nil, fmt.Errorf("connecting to storage policy api: %w", err)
+		}
+	}
+
+	if cfg.Automation.Category != "" {
+		vsc.rest = rest.NewClient(gc.Client)
+		vsc.tagMgr = tags.NewManager(vsc.rest)
+
+		if err := vsc.rest.Login(ctx, u.User); err != nil {
+			return nil, fmt.Errorf("logging into rest api: %w", err)
+		}
+	}
+
 	return &vsc, nil
 }
 
@@ -161,6 +353,25 @@ func parseCloudEvent(req []byte) (cloudEvent, error) {
 	return event, nil
 }
 
+// Actions the function takes, used to label metrics.
+const (
+	actionNone     = "none"
+	actionRelocate = "relocate"
+	actionEvacuate = "evacuate"
+)
+
+// alarmAction returns the action taken for an alarm name.
+func alarmAction(alarmName string) string {
+	switch alarmName {
+	case "VM Storage Usage":
+		return actionRelocate
+	case datastoreAlarm:
+		return actionEvacuate
+	}
+
+	return actionNone
+}
+
 func isStorageInAlarm(event cloudEvent) bool {
 	alarm := false
 
@@ -183,7 +394,9 @@ func eventVmMoRef(event cloudEvent) (types.ManagedObjectReference, error) {
 
 // isValidEvent ensures the necessary information has been sent.
 func isValidEvent(event cloudEvent) error {
-	if event.Data.Vm == nil || event.Data.Vm.Vm.Value == "" {
+	noVM := event.Data.Vm == nil || event.Data.Vm.Vm.Value == ""
+	noDatastore := event.Data.Ds == nil || event.Data.Ds.Datastore.Value == ""
+	if noVM && noDatastore {
 		return errors.New("empty managed object reference")
 	}
 
@@ -194,41 +407,210 @@ func isValidEvent(event cloudEvent) error {
 	return nil
 }
 
-func generateRelocSpec() types.VirtualMachineRelocateSpec {
-	// Resource pool managed object reference
-	poolMOR := types.ManagedObjectReference{
-		Type:  "ResourcePool",
-		Value: "resgroup-1030",
+// relocation is the relocation made for an alarm, either directly or once
+// approved. Disks moved one by one are kept apart from the spec, as their
+// locators don't round-trip through JSON.
+type relocation struct {
+	EventID string                       `json:"event_id"`
+	Alarm   string                       `json:"alarm"`
+	Action  string                       `json:"action"`
+	VM      types.ManagedObjectReference `json:"vm"`
+	placement
+}
+
+// relocateSpec returns the spec with the locators of the disk moves.
+func (r relocation) relocateSpec() types.VirtualMachineRelocateSpec {
+	spec := r.Spec
+	for _, d := range r.Disks {
+		spec.Disk = append(spec.Disk, d.locator())
+	}
+
+	return spec
+}
+
+// apply starts relocating the VM and records it in the audit trail. The
+// relocation task is observed in the background, the response does not wait
+// for it. It also returns the pre-flight warnings. A relocation blocked by the
+// pre-flight checks returns a *preflightError, one refused by the limits a
+// *limitError.
+func (r relocation) apply(ctx context.Context, cfg *vcConfig, clt *vsClient) (*object.Task, []finding, error) {
+	var findings []finding
+	if !cfg.Preflight.Disabled {
+		var err error
+		if findings, err = clt.preflight(ctx, cfg.Preflight, r); err != nil {
+			return nil, nil, fmt.Errorf("running pre-flight checks: %w", err)
+		}
+
+		for _, f := range findings {
+			logger(ctx).Warn("pre-flight finding", "check", f.Check, "severity", f.Severity, "message", f.Message)
+		}
+
+		if len(blocking(findings)) > 0 {
+			return nil, findings, &preflightError{findings: findings}
+		}
+	}
+	warned := warnings(findings)
+
+	// The relocation counts as in flight until its task finishes.
+	release, err := clt.acquireLimits(ctx, cfg.Limits, r.VM)
+	if err != nil {
+		return nil, warned, err
 	}
 
-	// Host managed object reference
-	hostMOR := types.ManagedObjectReference{
-		Type:  "HostSystem",
-		Value: "host-1031",
+	vm := object.NewVirtualMachine(clt.govmomi.Client, r.VM)
+	audit := newAuditor(cfg.Audit, clt.govmomi.Client)
+	rec := r.auditRecord(cfg.VCenter.Server)
+
+	// A VM whose placement can't be read is still relocated, its audit
+	// records just lack the before state.
+	before, err := vmPlacement(ctx, vm)
+	if err != nil {
+		logger(ctx).Warn("reading vm placement", "error", err)
+	} else {
+		rec.Before = before
 	}
 
-	// Datastore managed object reference
-	dsMOR := types.ManagedObjectReference{
-		Type:  "Datastore",
-		Value: "datastore-1032",
+	// Nor can a VM whose layout can't be read be moved back.
+	tx := &transaction{}
+	layout, layoutErr := clt.vmLayout(ctx, r.VM)
+	if layoutErr != nil {
+		logger(ctx).Warn("reading vm layout", "error", layoutErr)
 	}
 
-	spec := types.VirtualMachineRelocateSpec{
-		Host:         &hostMOR,
-		Pool:         &poolMOR,
-		Datastore:    &dsMOR,
-		DiskMoveType: "moveAllDiskBackingsAndConsolidate",
+	// Relocate the VM onto a different datastore.
+	start := time.Now()
+	relocCtx, relocSpan := startSpan(ctx, "VirtualMachine.Relocate", vmMoRef(r.VM.Value))
+	task, err := vm.Relocate(relocCtx, r.relocateSpec(), types.VirtualMachineMovePriorityHighPriority)
+	endSpan(relocSpan, err)
+	if err != nil {
+		release()
+		audit.record(ctx, rec.withResult(err))
+		return nil, warned, fmt.Errorf("relocating vm: %w", err)
 	}
 
-	return spec
+	rec.TaskID = task.Reference().Value
+	rec.Result = resultStarted
+	audit.record(ctx, rec)
+
+	if layoutErr == nil {
+		tx.done("relocate", func(ctx context.Context) error {
+			return clt.moveBack(ctx, r.VM, layout)
+		})
+	}
+
+	go observeTask(ctx, task, r.Alarm, r.Action, start, func(err error) {
+		defer release()
+
+		if err != nil {
+			r.rollBack(ctx, clt, tx, audit, rec, err)
+			return
+		}
+		audit.record(ctx, rec.withResult(nil))
+	})
+
+	return task, warned, nil
+}
+
+// rollBack moves back what a relocation that failed with cause half moved,
+// and records where the VM is afterwards as the relocation's final state.
+func (r relocation) rollBack(ctx context.Context, clt *vsClient, tx *transaction, audit *auditor, rec auditRecord, cause error) {
+	rbErr := tx.rollback(ctx)
+
+	rec = rec.withResult(cause)
+	rec.Result = resultRolledBack
+	if rbErr != nil {
+		rec.Result = resultRollbackFailed
+		rec.Error += "; " + rbErr.Error()
+	}
+	rollbacks.WithLabelValues(r.Action, rec.Result).Inc()
+
+	after, err := vmPlacement(ctx, object.NewVirtualMachine(clt.govmomi.Client, r.VM))
+	if err != nil {
+		logger(ctx).Warn("reading vm placement after rollback", "error", err)
+		rec.After = nil
+	} else {
+		rec.After = after
+	}
+
+	logger(ctx).Info("relocation rolled back", "result", rec.Result, "datastores", after.Datastores)
+	audit.record(ctx, rec)
 }
 
-func relocatedMessage(task *object.Task) string {
+// auditRecord returns the audit record of the relocation, before it is made.
+func (r relocation) auditRecord(vcenter string) auditRecord {
+	return auditRecord{
+		EventID: r.EventID,
+		Alarm:   r.Alarm,
+		VCenter: vcenter,
+		Object:  r.VM,
+		Action:  r.Action,
+		After:   r.placementState(),
+	}
+}
+
+// placementState is where a VM runs and is stored, recorded in the audit
+// trail around a relocation.
+type placementState struct {
+	Host       *types.ManagedObjectReference  `json:"host,omitempty"`
+	Pool       *types.ManagedObjectReference  `json:"pool,omitempty"`
+	Datastores []types.ManagedObjectReference `json:"datastores"`
+	Policies   []string                       `json:"policies,omitempty"`
+}
+
+// vmPlacement returns the VM's current placement.
+func vmPlacement(ctx context.Context, vm *object.VirtualMachine) (placementState, error) {
+	var moVM mo.VirtualMachine
+
+	ctx, span := startSpan(ctx, "PropertyCollector.Retrieve", vmMoRef(vm.Reference().Value))
+	err := timedRetrieve(func() error {
+		return vm.Properties(ctx, vm.Reference(), []string{"runtime.host", "resourcePool", "datastore"}, &moVM)
+	})
+	endSpan(span, err)
+	if err != nil {
+		return placementState{}, err
+	}
+
+	return placementState{
+		Host:       moVM.Runtime.Host,
+		Pool:       moVM.ResourcePool,
+		Datastores: moVM.Datastore,
+	}, nil
+}
+
+// placementState returns the placement the relocation asks for.
+func (r relocation) placementState() placementState {
+	spec := r.relocateSpec()
+	p := placementState{
+		Host:     spec.Host,
+		Pool:     spec.Pool,
+		Policies: r.Policies,
+	}
+
+	if spec.Datastore != nil {
+		p.Datastores = []types.ManagedObjectReference{*spec.Datastore}
+	}
+
+	for _, d := range spec.Disk {
+		if !containsRef(p.Datastores, d.Datastore) {
+			p.Datastores = append(p.Datastores, d.Datastore)
+		}
+	}
+
+	return p
+}
+
+func relocatedMessage(task *object.Task, policies []string, warned []finding) string {
 	if task == nil {
 		return "Nothing relocated."
 	}
 
 	msg := task.String()
+	if len(policies) > 0 {
+		msg += fmt.Sprintf(" Storage policies applied: %s.", strings.Join(policies, ", "))
+	}
+	if len(warned) > 0 {
+		msg += fmt.Sprintf(" Pre-flight warnings: %s.", joinFindings(warned))
+	}
 
 	return msg
 }
@@ -0,0 +1,812 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	handler "github.com/openfaas/templates-sdk/go-http"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestHandleIgnoresUnrelatedAlarms(t *testing.T) {
+	env := newSimEnv(t)
+	before := env.moVM()
+
+	tests := []struct {
+		name  string
+		alarm string
+		to    string
+	}{
+		{"storage yellow", "VM Storage Usage", "yellow"},
+		{"cpu red", "VM CPU Usage", "red"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := Handle(handler.Request{Body: alarmEvent(t, tc.alarm, tc.to, env.vm)})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("status = %d, want %d", res.StatusCode, http.StatusOK)
+			}
+
+			if after := env.moVM(); *after.Runtime.Host != *before.Runtime.Host {
+				t.Errorf("vm moved to %v", after.Runtime.Host)
+			}
+		})
+	}
+}
+
+func TestHandleRejectsInvalidEvents(t *testing.T) {
+	newSimEnv(t)
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{"not json", []byte("{")},
+		{"no vm", alarmEvent(t, "VM Storage Usage", "red", types.ManagedObjectReference{})},
+		{"no alarm", alarmEvent(t, "", "", types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"})},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := Handle(handler.Request{Body: tc.body})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			if res.StatusCode != http.StatusInternalServerError {
+				t.Errorf("status = %d, want %d", res.StatusCode, http.StatusInternalServerError)
+			}
+		})
+	}
+}
+
+func TestHandleRelocatesVM(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+	host := env.otherHost()
+
+	env.relocateTo(types.VirtualMachineRelocateSpec{
+		Host:         &host.Self,
+		Pool:         vm.ResourcePool,
+		Datastore:    &vm.Datastore[0],
+		DiskMoveType: "moveAllDiskBackingsAndConsolidate",
+	})
+
+	res, err := Handle(handler.Request{Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	if after := env.moVM(); *after.Runtime.Host != host.Self {
+		t.Errorf("vm host = %v, want %v", after.Runtime.Host, host.Self)
+	}
+}
+
+func TestHandleAuditsRelocation(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+	host := env.otherHost()
+
+	env.relocateTo(types.VirtualMachineRelocateSpec{
+		Host:      &host.Self,
+		Pool:      vm.ResourcePool,
+		Datastore: &vm.Datastore[0],
+	})
+
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	env.writeConfig(env.server, env.user, env.pass, fmt.Sprintf("[audit]\nfile = %q\n", file))
+
+	if _, err := Handle(handler.Request{Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The completion record is written once the task finishes in the background.
+	var lines []string
+	for i := 0; i < 50 && len(lines) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		b, _ := ioutil.ReadFile(file)
+		lines = strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+
+	if len(lines) != 2 {
+		t.Fatalf("audit records = %q, want started and success", lines)
+	}
+
+	for i, want := range []string{resultStarted, resultSuccess} {
+		var rec struct {
+			auditRecord
+			Before placementState `json:"before"`
+			After  placementState `json:"after"`
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &rec); err != nil {
+			t.Fatalf("decoding audit record %s: %v", lines[i], err)
+		}
+
+		if rec.Result != want || rec.TaskID == "" {
+			t.Errorf("record %d = %+v, want result %s with a task", i, rec.auditRecord, want)
+		}
+
+		if *rec.Before.Host != *vm.Runtime.Host || *rec.After.Host != host.Self {
+			t.Errorf("record %d host = %v -> %v, want %v -> %v", i, rec.Before.Host, rec.After.Host, vm.Runtime.Host, host.Self)
+		}
+	}
+}
+
+func TestHandleWaitsForApproval(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+	host := env.otherHost()
+
+	env.relocateTo(types.VirtualMachineRelocateSpec{
+		Host:      &host.Self,
+		Pool:      vm.ResourcePool,
+		Datastore: &vm.Datastore[0],
+	})
+
+	requests := make(chan approvalRequest, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req approvalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding approval request: %v", err)
+		}
+		requests <- req
+	}))
+	defer hook.Close()
+
+	env.writeConfig(env.server, env.user, env.pass, fmt.Sprintf(
+		"[approval]\nenabled = true\nstore = %q\nwebhook = %q\ncallback_url = \"http://gateway/function/vm-datastore-placement-fn\"\n",
+		t.TempDir(), hook.URL))
+
+	if _, err := Handle(handler.Request{Method: http.MethodPost, Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := <-requests
+	if *req.Change.Spec.Host != host.Self {
+		t.Errorf("approval request host = %v, want %v", req.Change.Spec.Host, host.Self)
+	}
+
+	if after := env.moVM(); *after.Runtime.Host != *vm.Runtime.Host {
+		t.Errorf("vm moved to %v before approval", after.Runtime.Host)
+	}
+
+	u, err := url.Parse(req.ApproveURL)
+	if err != nil {
+		t.Fatalf("parsing approve url: %v", err)
+	}
+
+	res, err := Handle(handler.Request{Method: http.MethodPost, QueryString: u.RawQuery})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("approve status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	if after := env.moVM(); *after.Runtime.Host != host.Self {
+		t.Errorf("vm host after approval = %v, want %v", after.Runtime.Host, host.Self)
+	}
+}
+
+func TestHandleRechecksApprovedRelocation(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(env *simEnv, small, large types.ManagedObjectReference)
+		want   string
+	}{
+		{"moved", func(env *simEnv, small, large types.ManagedObjectReference) {
+			simulator.Map.Get(env.vm).(*simulator.VirtualMachine).Datastore = []types.ManagedObjectReference{small}
+		}, "the vm moved from datastore(s)"},
+		{"target filled", func(env *simEnv, small, large types.ManagedObjectReference) {
+			simulator.Map.Get(large).(*simulator.Datastore).Summary.FreeSpace = 1 << 30
+		}, "has 1073741824 bytes free, 21474836480 needed"},
+		{"opted out", func(env *simEnv, small, large types.ManagedObjectReference) {
+			env.setAttribute(env.vm, "automation.placement", "disabled")
+		}, "automation disabled by attribute on vm-"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newSimEnv(t)
+			vm := env.moVM()
+			small := env.addDatastore("small", 10<<30)
+			large := env.addDatastore("large", 100<<30)
+			simulator.Map.Get(env.vm).(*simulator.VirtualMachine).Summary.Storage.Committed = 20 << 30
+
+			requests := make(chan approvalRequest, 1)
+			hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req approvalRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("decoding approval request: %v", err)
+				}
+				requests <- req
+			}))
+			defer hook.Close()
+
+			env.writeConfig(env.server, env.user, env.pass, fmt.Sprintf(
+				"[approval]\nenabled = true\nstore = %q\nwebhook = %q\ncallback_url = \"http://gateway/function/vm-datastore-placement-fn\"\n",
+				t.TempDir(), hook.URL), "[automation]\nattribute = \"automation.placement\"\n")
+
+			if _, err := Handle(handler.Request{Method: http.MethodPost, Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			req := <-requests
+
+			// The VM or its target changes while the relocation waits for
+			// approval.
+			tc.change(env, small, large)
+			before := env.moVM()
+
+			u, err := url.Parse(req.ApproveURL)
+			if err != nil {
+				t.Fatalf("parsing approve url: %v", err)
+			}
+
+			res, err := Handle(handler.Request{Method: http.MethodPost, QueryString: u.RawQuery})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if res.StatusCode != http.StatusConflict || !strings.Contains(string(res.Body), tc.want) {
+				t.Errorf("approve = %d %q, want %d with %q", res.StatusCode, res.Body, http.StatusConflict, tc.want)
+			}
+
+			if after := env.moVM(); !reflect.DeepEqual(after.Datastore, before.Datastore) || *after.Runtime.Host != *vm.Runtime.Host {
+				t.Errorf("vm moved to %v on %v after approval", after.Datastore, after.Runtime.Host)
+			}
+		})
+	}
+}
+
+func TestHandleQueuesOutOfWindow(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+	host := env.otherHost()
+
+	env.relocateTo(types.VirtualMachineRelocateSpec{
+		Host:      &host.Self,
+		Pool:      vm.ResourcePool,
+		Datastore: &vm.Datastore[0],
+	})
+	env.writeConfig(env.server, env.user, env.pass, fmt.Sprintf(
+		"[schedule]\nwindows = [\"* 22-23 * * *\"]\nout_of_window = \"queue\"\nqueue = %q\n", t.TempDir()))
+
+	at := time.Date(2026, 10, 20, 14, 0, 0, 0, time.UTC)
+	orig := now
+	now = func() time.Time { return at }
+	defer func() { now = orig }()
+
+	res, err := Handle(handler.Request{Method: http.MethodPost, Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "Queued relocating " + env.vm.Value; !strings.Contains(string(res.Body), want) {
+		t.Errorf("body = %q, want %q", res.Body, want)
+	}
+
+	if after := env.moVM(); *after.Runtime.Host != *vm.Runtime.Host {
+		t.Errorf("vm moved to %v out of window", after.Runtime.Host)
+	}
+
+	at = time.Date(2026, 10, 20, 22, 0, 0, 0, time.UTC)
+	if _, err := Handle(handler.Request{Method: http.MethodPost}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if after := env.moVM(); *after.Runtime.Host != host.Self {
+		t.Errorf("vm host in window = %v, want %v", after.Runtime.Host, host.Self)
+	}
+}
+
+func TestHandleReplacesQueuedRelocation(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+	host := env.otherHost()
+
+	env.relocateTo(types.VirtualMachineRelocateSpec{
+		Host:      &host.Self,
+		Pool:      vm.ResourcePool,
+		Datastore: &vm.Datastore[0],
+	})
+	queue := t.TempDir()
+	env.writeConfig(env.server, env.user, env.pass, fmt.Sprintf(
+		"[schedule]\nwindows = [\"* 22-23 * * *\"]\nout_of_window = \"queue\"\nqueue = %q\n", queue))
+
+	at := time.Date(2026, 10, 20, 14, 0, 0, 0, time.UTC)
+	orig := now
+	now = func() time.Time { return at }
+	defer func() { now = orig }()
+
+	for i := 0; i < 2; i++ {
+		res, err := Handle(handler.Request{Method: http.MethodPost, Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if want := "Queued relocating " + env.vm.Value; !strings.Contains(string(res.Body), want) {
+			t.Errorf("body = %q, want %q", res.Body, want)
+		}
+		at = at.Add(10 * time.Minute)
+	}
+
+	if got := len(actionStore(queue).ids()); got != 1 {
+		t.Errorf("queued relocations = %d, want 1", got)
+	}
+
+	at = time.Date(2026, 10, 20, 22, 0, 0, 0, time.UTC)
+	res, err := Handle(handler.Request{Method: http.MethodPost})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "Made 1 of 1 queued change(s)."; string(res.Body) != want {
+		t.Errorf("body = %q, want %q", res.Body, want)
+	}
+}
+
+func TestHandleRechecksQueuedRelocation(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+	large := env.addDatastore("large", 100<<30)
+	simulator.Map.Get(env.vm).(*simulator.VirtualMachine).Summary.Storage.Committed = 20 << 30
+	env.writeConfig(env.server, env.user, env.pass, fmt.Sprintf(
+		"[schedule]\nwindows = [\"* 22-23 * * *\"]\nout_of_window = \"queue\"\nqueue = %q\n", t.TempDir()))
+
+	at := time.Date(2026, 10, 20, 14, 0, 0, 0, time.UTC)
+	orig := now
+	now = func() time.Time { return at }
+	defer func() { now = orig }()
+
+	if _, err := Handle(handler.Request{Method: http.MethodPost, Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The target datastore fills up before the window opens.
+	simulator.Map.Get(large).(*simulator.Datastore).Summary.FreeSpace = 1 << 30
+
+	at = time.Date(2026, 10, 20, 22, 0, 0, 0, time.UTC)
+	res, err := Handle(handler.Request{Method: http.MethodPost})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "Made 0 of 1 queued change(s)."; string(res.Body) != want {
+		t.Errorf("body = %q, want %q", res.Body, want)
+	}
+
+	if after := env.moVM(); !reflect.DeepEqual(after.Datastore, vm.Datastore) {
+		t.Errorf("vm datastores = %v, want %v", after.Datastore, vm.Datastore)
+	}
+}
+
+func TestHandleRefusesOverLimit(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+	host := env.otherHost()
+
+	env.relocateTo(types.VirtualMachineRelocateSpec{
+		Host:      &host.Self,
+		Pool:      vm.ResourcePool,
+		Datastore: &vm.Datastore[0],
+	})
+
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	env.writeConfig(env.server, env.user, env.pass, fmt.Sprintf(
+		"[audit]\nfile = %q\n\n[limits]\nstore = %q\n\n[limits.global]\nmax_per_hour = 1\n",
+		file, filepath.Join(t.TempDir(), "limits.json")))
+
+	if _, err := Handle(handler.Request{Method: http.MethodPost, Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res, err := Handle(handler.Request{Method: http.MethodPost, Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "global limit of 1 changes per hour reached"; !strings.Contains(string(res.Body), want) {
+		t.Errorf("body = %q, want %q", res.Body, want)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("reading audit file: %v", err)
+	}
+
+	if !strings.Contains(string(b), `"result":"refused"`) {
+		t.Errorf("audit records = %s, want a refused relocation", b)
+	}
+}
+
+func TestHandleHonoursAutomationSetting(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+	host := env.otherHost()
+
+	env.relocateTo(types.VirtualMachineRelocateSpec{
+		Host:      &host.Self,
+		Pool:      vm.ResourcePool,
+		Datastore: &vm.Datastore[0],
+	})
+	env.writeConfig(env.server, env.user, env.pass, "[automation]\nattribute = \"automation.placement\"\n")
+	env.setAttribute(*vm.ResourcePool, "automation.placement", "disabled")
+
+	res, err := Handle(handler.Request{Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "automation disabled by attribute on " + vm.ResourcePool.Value; !strings.Contains(string(res.Body), want) {
+		t.Errorf("body = %q, want %q", res.Body, want)
+	}
+
+	if after := env.moVM(); *after.Runtime.Host != *vm.Runtime.Host {
+		t.Errorf("vm moved to %v", after.Runtime.Host)
+	}
+}
+
+func TestHandleServesMetrics(t *testing.T) {
+	env := newSimEnv(t)
+
+	if _, err := Handle(handler.Request{Method: http.MethodPost, Body: alarmEvent(t, "VM Storage Usage", "yellow", env.vm)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res, err := Handle(handler.Request{Method: http.MethodGet})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{
+		`veba_vm_datastore_move_events_total{action="relocate",alarm="VM Storage Usage",outcome="ignored"}`,
+		`veba_vm_datastore_move_property_retrieval_duration_seconds_count`,
+	} {
+		if !strings.Contains(string(res.Body), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestHandlePlacesOnDatastoreWithMostFreeSpace(t *testing.T) {
+	env := newSimEnv(t)
+
+	env.addDatastore("small", 10<<30)
+	large := env.addDatastore("large", 100<<30)
+	maintenance := env.addDatastore("maintenance", 200<<30)
+	simulator.Map.Get(maintenance).(*simulator.Datastore).Summary.MaintenanceMode = string(types.DatastoreSummaryMaintenanceModeStateInMaintenance)
+	pooled := env.addDatastore("pooled", 300<<30)
+	env.datastoreCluster("pod", pooled)
+
+	if _, err := Handle(handler.Request{Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if after := env.moVM(); len(after.Datastore) != 1 || after.Datastore[0] != large {
+		t.Errorf("vm datastores = %v, want %v", after.Datastore, large)
+	}
+}
+
+func TestHandleAsksStorageDRS(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+
+	target := env.addDatastore("target", 10<<30)
+	env.addDatastore("emptier", 100<<30)
+	pod := env.datastoreCluster("pod", append(vm.Datastore, target)...)
+	requests := env.storageDRS(types.VirtualMachineRelocateSpec{Datastore: &target})
+
+	if _, err := Handle(handler.Request{Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := <-requests
+	if req.Type != string(types.StoragePlacementSpecPlacementTypeRelocate) || *req.Vm != env.vm || *req.PodSelectionSpec.StoragePod != pod {
+		t.Errorf("recommendation request = %+v, want relocating %v in %v", req, env.vm, pod)
+	}
+
+	if after := env.moVM(); len(after.Datastore) != 1 || after.Datastore[0] != target {
+		t.Errorf("vm datastores = %v, want %v", after.Datastore, target)
+	}
+}
+
+func TestHandleChecksStorageDRSRecommendation(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+
+	// Storage DRS recommends the datastore the VM is already on.
+	env.datastoreCluster("pod", vm.Datastore...)
+	env.storageDRS(types.VirtualMachineRelocateSpec{Datastore: &vm.Datastore[0]})
+
+	res, err := Handle(handler.Request{Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "storage drs recommends datastore " + vm.Datastore[0].Value + ", which the vm is on"; !strings.Contains(string(res.Body), want) {
+		t.Errorf("body = %q, want %q", res.Body, want)
+	}
+}
+
+func TestHandleWithoutPlacement(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+
+	res, err := Handle(handler.Request{Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "Not relocating " + env.vm.Value; !strings.Contains(string(res.Body), want) {
+		t.Errorf("body = %q, want %q", res.Body, want)
+	}
+
+	if after := env.moVM(); after.Datastore[0] != vm.Datastore[0] {
+		t.Errorf("vm moved to %v", after.Datastore)
+	}
+}
+
+func TestHandleMovesLargestDisk(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+	target := env.addDatastore("target", 100<<30)
+
+	requests := make(chan approvalRequest, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req approvalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding approval request: %v", err)
+		}
+		requests <- req
+	}))
+	defer hook.Close()
+
+	env.writeConfig(env.server, env.user, env.pass, fmt.Sprintf(
+		"[approval]\nenabled = true\nstore = %q\nwebhook = %q\ncallback_url = \"http://gateway/function/vm-datastore-placement-fn\"\n\n"+
+			"[placement]\ndisks = \"largest\"\ndisk_format = \"thin\"\n",
+		t.TempDir(), hook.URL))
+
+	if _, err := Handle(handler.Request{Method: http.MethodPost, Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := <-requests
+	if req.Change.Spec.Datastore != nil {
+		t.Errorf("approval request moves the vm home to %v", req.Change.Spec.Datastore)
+	}
+
+	disks := vmDisks(vm)
+	if len(req.Change.Disks) != 1 || len(disks) == 0 {
+		t.Fatalf("approval request disks = %+v, want one of %d", req.Change.Disks, len(disks))
+	}
+
+	d := req.Change.Disks[0]
+	if d.Key != disks[0].disk.Key || d.Datastore != target || d.Format != formatThin {
+		t.Errorf("approval request disk = %+v, want %d to %v thin", d, disks[0].disk.Key, target)
+	}
+
+	u, err := url.Parse(req.ApproveURL)
+	if err != nil {
+		t.Fatalf("parsing approve url: %v", err)
+	}
+
+	res, err := Handle(handler.Request{Method: http.MethodPost, QueryString: u.RawQuery})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("approve status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+}
+
+func TestHandleChecksStoragePolicy(t *testing.T) {
+	env := newSimEnv(t)
+	vm := env.moVM()
+
+	env.addDatastore("emptier", 100<<30)
+	gold := env.addDatastore("gold", 10<<30)
+
+	associated := map[string]string{env.vm.Value: "p-gold"}
+	for _, d := range vmDisks(vm) {
+		associated[fmt.Sprintf("%s:%d", env.vm.Value, d.disk.Key)] = "p-gold"
+	}
+	env.storagePolicies(
+		map[string]string{"p-gold": "Gold"},
+		associated,
+		map[string][]types.ManagedObjectReference{"p-gold": {gold}},
+	)
+
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	env.writeConfig(env.server, env.user, env.pass, fmt.Sprintf(
+		"[audit]\nfile = %q\n\n[placement]\ncheck_storage_policy = true\n", file))
+
+	res, err := Handle(handler.Request{Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "Storage policies applied: Gold."; !strings.Contains(string(res.Body), want) {
+		t.Errorf("body = %q, want %q", res.Body, want)
+	}
+
+	if after := env.moVM(); len(after.Datastore) != 1 || after.Datastore[0] != gold {
+		t.Errorf("vm datastores = %v, want %v", after.Datastore, gold)
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("reading audit file: %v", err)
+	}
+
+	if !strings.Contains(string(b), `"policies":["Gold"]`) {
+		t.Errorf("audit records = %s, want the Gold policy", b)
+	}
+}
+
+func TestHandleEvacuatesDatastore(t *testing.T) {
+	env := newSimEnv(t)
+	ds := env.moVM().Datastore[0]
+	spare := env.addDatastore("spare", 500<<30)
+
+	simDS := simulator.Map.Get(ds).(*simulator.Datastore)
+	simDS.Summary.Capacity = 100 << 30
+	simDS.Summary.FreeSpace = 10 << 30
+
+	// 10 GB needs freeing to get below 80%, so the two largest VMs move.
+	vms := append([]types.ManagedObjectReference(nil), simDS.Vm...)
+	var largest []types.ManagedObjectReference
+	for i, ref := range vms {
+		size := int64(1 << 30)
+		if i < 2 {
+			size = int64(6-i) << 30
+			largest = append(largest, ref)
+		}
+
+		vm := simulator.Map.Get(ref).(*simulator.VirtualMachine)
+		vm.Storage.PerDatastoreUsage = []types.VirtualMachineUsageOnDatastore{{Datastore: ds, Committed: size}}
+	}
+
+	res, err := Handle(handler.Request{Body: datastoreAlarmEvent(t, "Datastore usage on disk", "red", ds)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "Evacuating " + largest[0].Value + ", " + largest[1].Value + " from " + ds.Value; !strings.Contains(string(res.Body), want) {
+		t.Errorf("body = %q, want %q", res.Body, want)
+	}
+
+	// The relocations run in the background.
+	moved := func() int {
+		n := 0
+		for _, ref := range vms {
+			var vm mo.VirtualMachine
+			if err := env.client.RetrieveOne(env.ctx, ref, []string{"datastore"}, &vm); err != nil {
+				t.Fatalf("retrieving vm: %v", err)
+			}
+			if vm.Datastore[0] == spare {
+				n++
+			}
+		}
+		return n
+	}
+
+	n := moved()
+	for i := 0; i < 50 && n < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		n = moved()
+	}
+
+	if n != 2 {
+		t.Errorf("vms moved = %d, want 2", n)
+	}
+}
+
+func TestHandleIgnoresDatastoreBelowTarget(t *testing.T) {
+	env := newSimEnv(t)
+	ds := env.moVM().Datastore[0]
+
+	simDS := simulator.Map.Get(ds).(*simulator.Datastore)
+	simDS.Summary.Capacity = 100 << 30
+	simDS.Summary.FreeSpace = 50 << 30
+
+	// 0.57 is 56.99999999999999 in percent, which is shown rounded.
+	env.writeConfig(env.server, env.user, env.pass, "[evacuation]\ntarget_usage = 0.57\n")
+
+	res, err := Handle(handler.Request{Body: datastoreAlarmEvent(t, "Datastore usage on disk", "red", ds)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := "its usage is below 57%."; !strings.Contains(string(res.Body), want) {
+		t.Errorf("body = %q, want %q", res.Body, want)
+	}
+}
+
+func TestHandleRunsPreflightChecks(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   types.CheckResult
+		ft       bool
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "checker error",
+			result:   types.CheckResult{Error: []types.LocalizedMethodFault{{Fault: &types.DisallowedMigrationDeviceAttached{}, LocalizedMessage: "device attached"}}},
+			wantCode: http.StatusConflict,
+			wantBody: "blocked by pre-flight checks: relocate: device attached",
+		},
+		{
+			name:     "fault tolerance",
+			ft:       true,
+			wantCode: http.StatusConflict,
+			wantBody: "blocked by pre-flight checks: fault_tolerance: the vm is fault tolerant",
+		},
+		{
+			name:     "checker warning",
+			result:   types.CheckResult{Warning: []types.LocalizedMethodFault{{Fault: &types.VMotionInterfaceIssue{}}}},
+			wantCode: http.StatusOK,
+			wantBody: "Pre-flight warnings: relocate: VMotionInterfaceIssue.",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newSimEnv(t)
+			vm := env.moVM()
+			host := env.otherHost()
+
+			env.relocateTo(types.VirtualMachineRelocateSpec{
+				Host:      &host.Self,
+				Pool:      vm.ResourcePool,
+				Datastore: &vm.Datastore[0],
+			})
+			env.provisioningChecker(tc.result)
+
+			if tc.ft {
+				simVM := simulator.Map.Get(env.vm).(*simulator.VirtualMachine)
+				simVM.Config.FtInfo = &types.FaultToleranceConfigInfo{Role: 1}
+			}
+
+			res, err := Handle(handler.Request{Body: alarmEvent(t, "VM Storage Usage", "red", env.vm)})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if res.StatusCode != tc.wantCode {
+				t.Errorf("status = %d, want %d", res.StatusCode, tc.wantCode)
+			}
+
+			if !strings.Contains(string(res.Body), tc.wantBody) {
+				t.Errorf("body = %q, want %q", res.Body, tc.wantBody)
+			}
+
+			moved := *env.moVM().Runtime.Host == host.Self
+			if want := tc.wantCode == http.StatusOK; moved != want {
+				t.Errorf("vm moved = %t, want %t", moved, want)
+			}
+		})
+	}
+}
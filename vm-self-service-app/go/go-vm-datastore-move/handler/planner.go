@@ -0,0 +1,356 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// errNoPlacement is returned when there is nowhere to relocate the VM to.
+var errNoPlacement = errors.New("no placement")
+
+// diskMoveType is how the VM's disks are moved when we place it ourselves.
+const diskMoveType = "moveAllDiskBackingsAndConsolidate"
+
+// placement is where a relocation moves the VM: the relocation spec, and the
+// moves of its disks when they are moved one by one.
+type placement struct {
+	Spec  types.VirtualMachineRelocateSpec `json:"spec"`
+	Disks []diskMove                       `json:"disks,omitempty"`
+	// Policies are the names of the storage policies the target datastore
+	// was checked against.
+	Policies []string `json:"policies,omitempty"`
+	// From are the VM's datastores when the relocation was planned.
+	From []types.ManagedObjectReference `json:"from,omitempty"`
+	// Need is the free space the relocation was planned to take on the
+	// target datastore.
+	Need int64 `json:"need_bytes,omitempty"`
+}
+
+// planRelocation returns the placement for the VM. A VM on a datastore
+// cluster with Storage DRS enabled is placed by Storage DRS, so its rules and
+// maintenance mode are respected, and its recommendation is checked like our
+// own placements. Otherwise the VM, or its disks, move to the datastore of its
+// host with the most free space, among those compatible with their storage
+// policies if these are checked. alarmed is the datastore in alarm, if the
+// event has one.
+func (clt *vsClient) planRelocation(ctx context.Context, cfg placementConfig, vm types.ManagedObjectReference, alarmed *types.ManagedObjectReference) (p placement, err error) {
+	ctx, span := startSpan(ctx, "placement.Plan", vmMoRef(vm.Value))
+	defer func() { endSpan(span, err) }()
+
+	pc := property.DefaultCollector(clt.govmomi.Client)
+
+	var moVM mo.VirtualMachine
+	props := []string{"datastore", "runtime.host", "summary.storage", "config.hardware.device"}
+	if err := timedRetrieve(func() error {
+		return pc.RetrieveOne(ctx, vm, props, &moVM)
+	}); err != nil {
+		return p, fmt.Errorf("retrieving vm: %w", err)
+	}
+
+	current, err := clt.datastores(ctx, moVM.Datastore)
+	if err != nil {
+		return p, err
+	}
+
+	p.From = moVM.Datastore
+	for _, ds := range current {
+		pod, err := clt.sdrsPod(ctx, ds)
+		if err != nil {
+			return p, err
+		}
+
+		if pod != nil {
+			return clt.placeByStorageDRS(ctx, cfg, moVM, *pod, alarmed, p)
+		}
+	}
+
+	if moVM.Runtime.Host == nil {
+		return p, fmt.Errorf("%w: the vm has no host", errNoPlacement)
+	}
+
+	var host mo.HostSystem
+	if err := timedRetrieve(func() error {
+		return pc.RetrieveOne(ctx, *moVM.Runtime.Host, []string{"datastore"}, &host)
+	}); err != nil {
+		return p, fmt.Errorf("retrieving host: %w", err)
+	}
+
+	candidates, err := clt.datastores(ctx, host.Datastore)
+	if err != nil {
+		return p, err
+	}
+
+	// The whole VM moves off its datastores, or the disks off theirs.
+	var need int64
+	if moVM.Summary.Storage != nil {
+		need = moVM.Summary.Storage.Committed
+	}
+	from := moVM.Datastore
+	moving := vmDisks(moVM)
+	home := cfg.Disks == "" || cfg.Disks == disksAll
+
+	var selected []vmDisk
+	if cfg.perDisk() {
+		source := fullestDatastore(current)
+		if alarmed != nil {
+			source = *alarmed
+		}
+
+		selected = cfg.selectDisks(moving, source)
+		if len(selected) == 0 {
+			return p, fmt.Errorf("%w: no disk of the vm to move", errNoPlacement)
+		}
+
+		if !home {
+			need, from, moving = 0, nil, selected
+			for _, d := range selected {
+				need += d.disk.CapacityInBytes
+				from = append(from, d.datastore)
+			}
+		}
+	}
+
+	if cfg.CheckStoragePolicy {
+		candidates, p.Policies, err = clt.compliantDatastores(ctx, cfg, vm, home, moving, candidates)
+		if err != nil {
+			return p, err
+		}
+	}
+
+	ds, ok := rankDatastores(candidates, from, need)
+	if !ok {
+		var compatible string
+		if len(p.Policies) > 0 {
+			compatible = " compatible with " + strings.Join(p.Policies, ", ")
+		}
+
+		return p, fmt.Errorf("%w: no datastore of host %s outside storage drs%s has %d bytes free", errNoPlacement, moVM.Runtime.Host.Value, compatible, need)
+	}
+
+	if clt.reserved == nil {
+		clt.reserved = make(map[types.ManagedObjectReference]int64)
+	}
+	clt.reserved[ds] += need
+	p.Need = need
+
+	// Moving only some disks leaves the VM's home where it is.
+	if home {
+		p.Spec = types.VirtualMachineRelocateSpec{
+			Datastore:    &ds,
+			DiskMoveType: diskMoveType,
+		}
+	}
+
+	for _, d := range selected {
+		p.Disks = append(p.Disks, diskMove{
+			Key:       d.disk.Key,
+			Label:     d.label,
+			SizeBytes: d.disk.CapacityInBytes,
+			Mode:      d.mode,
+			Datastore: ds,
+			Format:    cfg.DiskFormat,
+			Profile:   cfg.StorageProfile,
+		})
+	}
+
+	return p, nil
+}
+
+// placeByStorageDRS returns the placement Storage DRS recommends in the
+// datastore cluster. The recommended datastore must not be one the VM is on
+// or the one in alarm, must have room left by the relocations already
+// planned and, if checked, be compatible with the VM's storage policies.
+// Storage DRS moves the whole VM, so the disks to move aren't selected.
+func (clt *vsClient) placeByStorageDRS(ctx context.Context, cfg placementConfig, moVM mo.VirtualMachine, pod types.ManagedObjectReference, alarmed *types.ManagedObjectReference, p placement) (placement, error) {
+	spec, err := clt.recommendPlacement(ctx, moVM.Self, pod)
+	if err != nil {
+		return p, err
+	}
+
+	if cfg.perDisk() {
+		logger(ctx).Warn("storage drs moves the whole vm, not selecting disks", "pod", pod.Value, "disks", cfg.Disks)
+	}
+
+	target := spec.Datastore
+	switch {
+	case target == nil:
+		return p, fmt.Errorf("%w: storage drs recommends no datastore in %s", errNoPlacement, pod.Value)
+	case containsRef(p.From, *target):
+		return p, fmt.Errorf("%w: storage drs recommends datastore %s, which the vm is on", errNoPlacement, target.Value)
+	case alarmed != nil && *target == *alarmed:
+		return p, fmt.Errorf("%w: storage drs recommends datastore %s, which is in alarm", errNoPlacement, target.Value)
+	}
+
+	dss, err := clt.datastores(ctx, []types.ManagedObjectReference{*target})
+	if err != nil {
+		return p, err
+	}
+
+	var need int64
+	if moVM.Summary.Storage != nil {
+		need = moVM.Summary.Storage.Committed
+	}
+
+	if len(dss) != 1 || dss[0].Summary.FreeSpace <= need {
+		return p, fmt.Errorf("%w: datastore %s recommended by storage drs doesn't have %d bytes free", errNoPlacement, target.Value, need)
+	}
+
+	if cfg.CheckStoragePolicy {
+		dss, p.Policies, err = clt.compliantDatastores(ctx, cfg, moVM.Self, true, vmDisks(moVM), dss)
+		if err != nil {
+			return p, err
+		}
+
+		if len(dss) == 0 {
+			return p, fmt.Errorf("%w: datastore %s recommended by storage drs isn't compatible with %s", errNoPlacement, target.Value, strings.Join(p.Policies, ", "))
+		}
+	}
+
+	if clt.reserved == nil {
+		clt.reserved = make(map[types.ManagedObjectReference]int64)
+	}
+	clt.reserved[*target] += need
+	p.Spec, p.Need = spec, need
+
+	return p, nil
+}
+
+// datastores retrieves the datastores' summary and parent. Their free space
+// leaves out the space reserved by relocations already planned.
+func (clt *vsClient) datastores(ctx context.Context, refs []types.ManagedObjectReference) ([]mo.Datastore, error) {
+	var dss []mo.Datastore
+	if len(refs) == 0 {
+		return dss, nil
+	}
+
+	pc := property.DefaultCollector(clt.govmomi.Client)
+	if err := timedRetrieve(func() error {
+		return pc.Retrieve(ctx, refs, []string{"summary", "parent"}, &dss)
+	}); err != nil {
+		return nil, fmt.Errorf("retrieving datastores: %w", err)
+	}
+
+	for i := range dss {
+		dss[i].Summary.FreeSpace -= clt.reserved[dss[i].Self]
+	}
+
+	return dss, nil
+}
+
+// sdrsPod returns the datastore cluster the datastore is in, if Storage DRS
+// is enabled on it.
+func (clt *vsClient) sdrsPod(ctx context.Context, ds mo.Datastore) (*types.ManagedObjectReference, error) {
+	if ds.Parent == nil || ds.Parent.Type != "StoragePod" {
+		return nil, nil
+	}
+
+	var pod mo.StoragePod
+	pc := property.DefaultCollector(clt.govmomi.Client)
+	if err := timedRetrieve(func() error {
+		return pc.RetrieveOne(ctx, *ds.Parent, []string{"podStorageDrsEntry"}, &pod)
+	}); err != nil {
+		return nil, fmt.Errorf("retrieving datastore cluster: %w", err)
+	}
+
+	if e := pod.PodStorageDrsEntry; e == nil || !e.StorageDrsConfig.PodConfig.Enabled {
+		return nil, nil
+	}
+
+	return ds.Parent, nil
+}
+
+// recommendPlacement asks Storage DRS where in the datastore cluster to
+// relocate the VM to. The top recommendation's spec is used rather than
+// applying the recommendation, as approved and queued relocations run long
+// after recommendations expire.
+func (clt *vsClient) recommendPlacement(ctx context.Context, vm, pod types.ManagedObjectReference) (types.VirtualMachineRelocateSpec, error) {
+	ctx, span := startSpan(ctx, "StorageResourceManager.RecommendDatastores", vmMoRef(vm.Value))
+	srm := object.NewStorageResourceManager(clt.govmomi.Client)
+	res, err := srm.RecommendDatastores(ctx, types.StoragePlacementSpec{
+		Type:             string(types.StoragePlacementSpecPlacementTypeRelocate),
+		Vm:               &vm,
+		PodSelectionSpec: types.StorageDrsPodSelectionSpec{StoragePod: &pod},
+	})
+	endSpan(span, err)
+	if err != nil {
+		return types.VirtualMachineRelocateSpec{}, fmt.Errorf("getting storage drs recommendations: %w", err)
+	}
+
+	spec, ok := topRecommendation(res.Recommendations)
+	if !ok {
+		return spec, fmt.Errorf("%w: storage drs has no recommendation in %s", errNoPlacement, pod.Value)
+	}
+
+	return spec, nil
+}
+
+// topRecommendation returns the relocate spec of the highest rated storage
+// placement recommendation, the first of equally rated ones.
+func topRecommendation(recs []types.ClusterRecommendation) (types.VirtualMachineRelocateSpec, bool) {
+	var (
+		best  *types.StoragePlacementAction
+		score int32
+	)
+
+	for _, r := range recs {
+		for _, a := range r.Action {
+			if action, ok := a.(*types.StoragePlacementAction); ok && (best == nil || r.Rating > score) {
+				best, score = action, r.Rating
+			}
+		}
+	}
+
+	if best == nil {
+		return types.VirtualMachineRelocateSpec{}, false
+	}
+
+	return best.RelocateSpec, true
+}
+
+// rankDatastores returns the datastore with the most free space that the VM
+// can move to: one it isn't moving from, outside any datastore cluster,
+// accessible, not in maintenance mode and with more than need bytes free.
+func rankDatastores(dss []mo.Datastore, from []types.ManagedObjectReference, need int64) (types.ManagedObjectReference, bool) {
+	var eligible []mo.Datastore
+
+	for _, ds := range dss {
+		s := ds.Summary
+		switch {
+		case containsRef(from, ds.Self):
+		case ds.Parent != nil && ds.Parent.Type == "StoragePod":
+		case !s.Accessible:
+		case s.MaintenanceMode != "" && s.MaintenanceMode != string(types.DatastoreSummaryMaintenanceModeStateNormal):
+		case s.FreeSpace <= need:
+		default:
+			eligible = append(eligible, ds)
+		}
+	}
+
+	if len(eligible) == 0 {
+		return types.ManagedObjectReference{}, false
+	}
+
+	sort.SliceStable(eligible, func(i, j int) bool {
+		return eligible[i].Summary.FreeSpace > eligible[j].Summary.FreeSpace
+	})
+
+	return eligible[0].Self, true
+}
+
+func containsRef(refs []types.ManagedObjectReference, ref types.ManagedObjectReference) bool {
+	for _, r := range refs {
+		if r == ref {
+			return true
+		}
+	}
+
+	return false
+}
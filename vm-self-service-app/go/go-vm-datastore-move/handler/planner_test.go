@@ -0,0 +1,94 @@
+package function
+
+import (
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestRankDatastores(t *testing.T) {
+	ds := func(name string, free int64, mod func(*mo.Datastore)) mo.Datastore {
+		d := mo.Datastore{
+			Summary: types.DatastoreSummary{
+				Name:            name,
+				FreeSpace:       free,
+				Accessible:      true,
+				MaintenanceMode: string(types.DatastoreSummaryMaintenanceModeStateNormal),
+			},
+		}
+		d.Self = types.ManagedObjectReference{Type: "Datastore", Value: name}
+		d.Parent = &types.ManagedObjectReference{Type: "Folder", Value: "group-s5"}
+		if mod != nil {
+			mod(&d)
+		}
+
+		return d
+	}
+
+	current := []types.ManagedObjectReference{{Type: "Datastore", Value: "ds-1"}}
+
+	tests := []struct {
+		name string
+		dss  []mo.Datastore
+		need int64
+		want string
+	}{
+		{"most free space", []mo.Datastore{ds("ds-2", 20, nil), ds("ds-3", 30, nil)}, 10, "ds-3"},
+		{"not the current datastore", []mo.Datastore{ds("ds-1", 90, nil), ds("ds-2", 20, nil)}, 10, "ds-2"},
+		{"not in a datastore cluster", []mo.Datastore{ds("ds-2", 20, nil), ds("ds-3", 30, func(d *mo.Datastore) {
+			d.Parent = &types.ManagedObjectReference{Type: "StoragePod", Value: "group-p1"}
+		})}, 10, "ds-2"},
+		{"not inaccessible", []mo.Datastore{ds("ds-2", 20, nil), ds("ds-3", 30, func(d *mo.Datastore) {
+			d.Summary.Accessible = false
+		})}, 10, "ds-2"},
+		{"not in maintenance", []mo.Datastore{ds("ds-2", 20, nil), ds("ds-3", 30, func(d *mo.Datastore) {
+			d.Summary.MaintenanceMode = string(types.DatastoreSummaryMaintenanceModeStateEnteringMaintenance)
+		})}, 10, "ds-2"},
+		{"enough free space", []mo.Datastore{ds("ds-2", 20, nil)}, 20, ""},
+		{"none", nil, 0, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := rankDatastores(tc.dss, current, tc.need)
+			if ok != (tc.want != "") || got.Value != tc.want {
+				t.Errorf("rankDatastores() = %v, %t, want %q", got, ok, tc.want)
+			}
+		})
+	}
+}
+
+func TestTopRecommendation(t *testing.T) {
+	rec := func(rating int32, ds string) types.ClusterRecommendation {
+		ref := types.ManagedObjectReference{Type: "Datastore", Value: ds}
+
+		return types.ClusterRecommendation{
+			Rating: rating,
+			Action: []types.BaseClusterAction{&types.StoragePlacementAction{
+				RelocateSpec: types.VirtualMachineRelocateSpec{Datastore: &ref},
+				Destination:  ref,
+			}},
+		}
+	}
+
+	tests := []struct {
+		name string
+		recs []types.ClusterRecommendation
+		want string
+	}{
+		{"highest rating", []types.ClusterRecommendation{rec(2, "ds-1"), rec(4, "ds-2"), rec(3, "ds-3")}, "ds-2"},
+		{"first of equal ratings", []types.ClusterRecommendation{rec(4, "ds-1"), rec(4, "ds-2")}, "ds-1"},
+		{"only placement actions", []types.ClusterRecommendation{{Rating: 5, Action: []types.BaseClusterAction{&types.ClusterAction{}}}}, ""},
+		{"none", nil, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, ok := topRecommendation(tc.recs)
+			if ok != (tc.want != "") || (ok && spec.Datastore.Value != tc.want) {
+				t.Errorf("topRecommendation() = %+v, %t, want %q", spec, ok, tc.want)
+			}
+		})
+	}
+}
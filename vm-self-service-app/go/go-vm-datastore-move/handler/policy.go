@@ -0,0 +1,119 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/vmware/govmomi/pbm"
+	"github.com/vmware/govmomi/pbm/methods"
+	pbmtypes "github.com/vmware/govmomi/pbm/types"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// compliantDatastores returns the candidates compatible with the storage
+// policies of what moves: the VM's home if it moves, and the moving disks,
+// which get the configured storage profile if there is one. It also returns
+// the names of the policies checked.
+func (clt *vsClient) compliantDatastores(ctx context.Context, cfg placementConfig, vm types.ManagedObjectReference, home bool, disks []vmDisk, candidates []mo.Datastore) (compliant []mo.Datastore, names []string, err error) {
+	ctx, span := startSpan(ctx, "pbm.CheckRequirements", vmMoRef(vm.Value))
+	defer func() { endSpan(span, err) }()
+
+	var objects []pbmtypes.PbmServerObjectRef
+	if home {
+		objects = append(objects, pbmtypes.PbmServerObjectRef{
+			ObjectType: string(pbmtypes.PbmObjectTypeVirtualMachine),
+			Key:        vm.Value,
+		})
+	}
+
+	var ids []pbmtypes.PbmProfileId
+	if cfg.StorageProfile != "" {
+		ids = append(ids, pbmtypes.PbmProfileId{UniqueId: cfg.StorageProfile})
+	} else {
+		for _, d := range disks {
+			objects = append(objects, pbmtypes.PbmServerObjectRef{
+				ObjectType: string(pbmtypes.PbmObjectTypeVirtualDiskId),
+				Key:        vm.Value + ":" + strconv.Itoa(int(d.disk.Key)),
+			})
+		}
+	}
+
+	for _, obj := range objects {
+		res, err := methods.PbmQueryAssociatedProfile(ctx, clt.pbm, &pbmtypes.PbmQueryAssociatedProfile{
+			This:   clt.pbm.ServiceContent.ProfileManager,
+			Entity: obj,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("querying storage policy of %s: %w", obj.Key, err)
+		}
+
+		for _, id := range res.Returnval {
+			if !containsProfile(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		return candidates, nil, nil
+	}
+
+	profiles, err := clt.pbm.RetrieveContent(ctx, ids)
+	if err != nil {
+		return nil, nil, fmt.Errorf("retrieving storage policies: %w", err)
+	}
+
+	for _, p := range profiles {
+		if p != nil {
+			names = append(names, p.GetPbmProfile().Name)
+		}
+	}
+
+	hubs := make([]pbmtypes.PbmPlacementHub, 0, len(candidates))
+	for _, ds := range candidates {
+		hubs = append(hubs, pbmtypes.PbmPlacementHub{HubType: ds.Self.Type, HubId: ds.Self.Value})
+	}
+
+	// A datastore must be compatible with every policy.
+	compliant = candidates
+	for _, id := range ids {
+		res, err := clt.pbm.CheckRequirements(ctx, hubs, nil, []pbmtypes.BasePbmPlacementRequirement{
+			&pbmtypes.PbmPlacementCapabilityProfileRequirement{ProfileId: id},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("checking datastores against storage policy %s: %w", id.UniqueId, err)
+		}
+
+		compliant = compatibleWith(compliant, res)
+	}
+
+	return compliant, names, nil
+}
+
+// compatibleWith returns the datastores among dss that are compatible hubs
+// in the placement result.
+func compatibleWith(dss []mo.Datastore, res pbm.PlacementCompatibilityResult) []mo.Datastore {
+	var compatible []mo.Datastore
+	for _, ds := range dss {
+		for _, hub := range res.CompatibleDatastores() {
+			if hub.HubType == ds.Self.Type && hub.HubId == ds.Self.Value {
+				compatible = append(compatible, ds)
+				break
+			}
+		}
+	}
+
+	return compatible
+}
+
+func containsProfile(ids []pbmtypes.PbmProfileId, id pbmtypes.PbmProfileId) bool {
+	for _, i := range ids {
+		if i.UniqueId == id.UniqueId {
+			return true
+		}
+	}
+
+	return false
+}
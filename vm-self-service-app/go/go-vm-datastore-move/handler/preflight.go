@@ -0,0 +1,326 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// Severities of pre-flight findings.
+const (
+	severityBlocking = "blocking"
+	severityWarning  = "warning"
+)
+
+// Pre-flight checks. checkRelocate is vCenter's own, the others are rules of
+// ours whose severity can be configured.
+const (
+	checkRelocate         = "relocate"
+	checkSnapshots        = "snapshots"
+	checkMedia            = "media"
+	checkIndependentDisks = "independent_disks"
+	checkFaultTolerance   = "fault_tolerance"
+	checkEncryption       = "encryption"
+)
+
+// defaultSeverities are the severities of the rule checks.
+var defaultSeverities = map[string]string{
+	checkSnapshots:        severityWarning,
+	checkMedia:            severityBlocking,
+	checkIndependentDisks: severityWarning,
+	checkFaultTolerance:   severityBlocking,
+	checkEncryption:       severityWarning,
+}
+
+// preflightConfig is the optional [preflight] section of vcconfig. Relocations
+// are checked before they start, and blocked by blocking findings.
+type preflightConfig struct {
+	// Disabled skips the checks.
+	Disabled bool `toml:"disabled"`
+	// Block and Warn are rule checks made blocking or only warned about,
+	// e.g. ["snapshots"].
+	Block []string `toml:"block"`
+	Warn  []string `toml:"warn"`
+}
+
+func (c preflightConfig) validate() error {
+	for _, check := range append(append([]string(nil), c.Block...), c.Warn...) {
+		if _, ok := defaultSeverities[check]; !ok {
+			return fmt.Errorf("preflight check %q is unknown", check)
+		}
+	}
+
+	return nil
+}
+
+// severity returns the severity of the rule check.
+func (c preflightConfig) severity(check string) string {
+	switch {
+	case containsString(c.Block, check):
+		return severityBlocking
+	case containsString(c.Warn, check):
+		return severityWarning
+	}
+
+	return defaultSeverities[check]
+}
+
+// finding is what a pre-flight check found wrong with a relocation.
+type finding struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (f finding) String() string {
+	return f.Check + ": " + f.Message
+}
+
+// preflightError is returned when a relocation is blocked by pre-flight
+// findings.
+type preflightError struct {
+	findings []finding
+}
+
+func (e *preflightError) Error() string {
+	return "blocked by pre-flight checks: " + joinFindings(blocking(e.findings))
+}
+
+// blocking returns the blocking findings.
+func blocking(findings []finding) []finding {
+	var blocked []finding
+	for _, f := range findings {
+		if f.Severity == severityBlocking {
+			blocked = append(blocked, f)
+		}
+	}
+
+	return blocked
+}
+
+// warnings returns the findings that only warn.
+func warnings(findings []finding) []finding {
+	var warned []finding
+	for _, f := range findings {
+		if f.Severity == severityWarning {
+			warned = append(warned, f)
+		}
+	}
+
+	return warned
+}
+
+func joinFindings(findings []finding) string {
+	s := make([]string, 0, len(findings))
+	for _, f := range findings {
+		s = append(s, f.String())
+	}
+
+	return strings.Join(s, "; ")
+}
+
+// preflight checks the relocation with vCenter's provisioning checker and our
+// rules, and returns their findings.
+func (clt *vsClient) preflight(ctx context.Context, cfg preflightConfig, r relocation) (findings []finding, err error) {
+	ctx, span := startSpan(ctx, "preflight.Check", vmMoRef(r.VM.Value))
+	defer func() { endSpan(span, err) }()
+
+	pc := property.DefaultCollector(clt.govmomi.Client)
+
+	var moVM mo.VirtualMachine
+	props := []string{"snapshot", "config.hardware.device", "config.ftInfo", "config.keyId"}
+	if err := timedRetrieve(func() error {
+		return pc.RetrieveOne(ctx, r.VM, props, &moVM)
+	}); err != nil {
+		return nil, fmt.Errorf("retrieving vm: %w", err)
+	}
+
+	var refs []types.ManagedObjectReference
+	for _, m := range connectedMedia(moVM) {
+		if !containsRef(refs, m.datastore) {
+			refs = append(refs, m.datastore)
+		}
+	}
+
+	var dss []mo.Datastore
+	if len(refs) > 0 {
+		if err := timedRetrieve(func() error {
+			return pc.Retrieve(ctx, refs, []string{"summary"}, &dss)
+		}); err != nil {
+			return nil, fmt.Errorf("retrieving datastores of media: %w", err)
+		}
+	}
+
+	local := make(map[types.ManagedObjectReference]bool)
+	for _, ds := range dss {
+		local[ds.Self] = ds.Summary.MultipleHostAccess == nil || !*ds.Summary.MultipleHostAccess
+	}
+
+	checked, err := clt.checkRelocate(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(checked, cfg.ruleFindings(moVM, local)...), nil
+}
+
+// checkRelocate runs vCenter's provisioning checker on the relocation. Its
+// errors block the relocation, its warnings only warn.
+func (clt *vsClient) checkRelocate(ctx context.Context, r relocation) ([]finding, error) {
+	checker := clt.govmomi.ServiceContent.VmProvisioningChecker
+	if checker == nil {
+		return nil, nil
+	}
+
+	res, err := methods.CheckRelocate_Task(ctx, clt.govmomi.Client, &types.CheckRelocate_Task{
+		This: *checker,
+		Vm:   r.VM,
+		Spec: r.relocateSpec(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("checking relocation: %w", err)
+	}
+
+	info, err := object.NewTask(clt.govmomi.Client, res.Returnval).WaitForResult(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("checking relocation: %w", err)
+	}
+
+	results, _ := info.Result.(types.ArrayOfCheckResult)
+
+	var findings []finding
+	for _, cr := range results.CheckResult {
+		for _, f := range cr.Error {
+			findings = append(findings, finding{Check: checkRelocate, Severity: severityBlocking, Message: faultMessage(f)})
+		}
+
+		for _, f := range cr.Warning {
+			findings = append(findings, finding{Check: checkRelocate, Severity: severityWarning, Message: faultMessage(f)})
+		}
+	}
+
+	return findings, nil
+}
+
+// faultMessage returns the fault's message, or its type if it has none.
+func faultMessage(f types.LocalizedMethodFault) string {
+	if f.LocalizedMessage != "" {
+		return f.LocalizedMessage
+	}
+
+	if f.Fault == nil {
+		return "unknown fault"
+	}
+
+	return strings.TrimPrefix(fmt.Sprintf("%T", f.Fault), "*types.")
+}
+
+// ruleFindings returns the findings of our rules for the VM. local holds
+// whether the datastores of its media are local to a host.
+func (c preflightConfig) ruleFindings(moVM mo.VirtualMachine, local map[types.ManagedObjectReference]bool) []finding {
+	var findings []finding
+	add := func(check, format string, args ...interface{}) {
+		findings = append(findings, finding{Check: check, Severity: c.severity(check), Message: fmt.Sprintf(format, args...)})
+	}
+
+	if n := countSnapshots(moVM.Snapshot); n > 0 {
+		add(checkSnapshots, "the vm has %d snapshot(s), which move with it", n)
+	}
+
+	for _, m := range connectedMedia(moVM) {
+		if local[m.datastore] {
+			add(checkMedia, "%s is connected to %s on local datastore %s", m.label, m.file, m.datastore.Value)
+		}
+	}
+
+	for _, d := range vmDisks(moVM) {
+		if strings.HasPrefix(d.mode, "independent") {
+			add(checkIndependentDisks, "%s is %s, so it isn't in snapshots", diskName(d), d.mode)
+		}
+	}
+
+	if moVM.Config != nil {
+		if moVM.Config.FtInfo != nil {
+			add(checkFaultTolerance, "the vm is fault tolerant")
+		}
+
+		if moVM.Config.KeyId != nil {
+			add(checkEncryption, "the vm is encrypted, the target must use key provider %s", moVM.Config.KeyId.ProviderId.Id)
+		}
+	}
+
+	return findings
+}
+
+func diskName(d vmDisk) string {
+	if d.label != "" {
+		return d.label
+	}
+
+	return fmt.Sprintf("disk %d", d.disk.Key)
+}
+
+func countSnapshots(info *types.VirtualMachineSnapshotInfo) int {
+	if info == nil {
+		return 0
+	}
+
+	var count func([]types.VirtualMachineSnapshotTree) int
+	count = func(trees []types.VirtualMachineSnapshotTree) int {
+		n := len(trees)
+		for _, t := range trees {
+			n += count(t.ChildSnapshotList)
+		}
+
+		return n
+	}
+
+	return count(info.RootSnapshotList)
+}
+
+// medium is an ISO or floppy image connected to the VM.
+type medium struct {
+	label     string
+	file      string
+	datastore types.ManagedObjectReference
+}
+
+// connectedMedia returns the VM's connected CD-ROM and floppy images.
+func connectedMedia(moVM mo.VirtualMachine) []medium {
+	if moVM.Config == nil {
+		return nil
+	}
+
+	var media []medium
+	for _, dev := range moVM.Config.Hardware.Device {
+		var b *types.VirtualDeviceFileBackingInfo
+		switch backing := dev.GetVirtualDevice().Backing.(type) {
+		case *types.VirtualCdromIsoBackingInfo:
+			b = &backing.VirtualDeviceFileBackingInfo
+		case *types.VirtualFloppyImageBackingInfo:
+			b = &backing.VirtualDeviceFileBackingInfo
+		default:
+			continue
+		}
+
+		c := dev.GetVirtualDevice().Connectable
+		if b.Datastore == nil || c == nil || !c.Connected {
+			continue
+		}
+
+		m := medium{label: fmt.Sprintf("device %d", dev.GetVirtualDevice().Key), file: b.FileName, datastore: *b.Datastore}
+		if info := dev.GetVirtualDevice().DeviceInfo; info != nil {
+			m.label = info.GetDescription().Label
+		}
+
+		media = append(media, m)
+	}
+
+	return media
+}
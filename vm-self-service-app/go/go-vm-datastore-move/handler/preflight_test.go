@@ -0,0 +1,62 @@
+package function
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestRuleFindings(t *testing.T) {
+	local := types.ManagedObjectReference{Type: "Datastore", Value: "ds-local"}
+	shared := types.ManagedObjectReference{Type: "Datastore", Value: "ds-shared"}
+
+	iso := func(key int32, ds types.ManagedObjectReference, connected bool) types.BaseVirtualDevice {
+		return &types.VirtualCdrom{VirtualDevice: types.VirtualDevice{
+			Key:         key,
+			Backing:     &types.VirtualCdromIsoBackingInfo{VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: "[ds] os.iso", Datastore: &ds}},
+			Connectable: &types.VirtualDeviceConnectInfo{Connected: connected},
+		}}
+	}
+
+	disk := &types.VirtualDisk{VirtualDevice: types.VirtualDevice{
+		Key:     2000,
+		Backing: &types.VirtualDiskFlatVer2BackingInfo{DiskMode: string(types.VirtualDiskModeIndependent_persistent)},
+	}}
+
+	moVM := mo.VirtualMachine{
+		Snapshot: &types.VirtualMachineSnapshotInfo{RootSnapshotList: []types.VirtualMachineSnapshotTree{
+			{ChildSnapshotList: []types.VirtualMachineSnapshotTree{{}}},
+		}},
+		Config: &types.VirtualMachineConfigInfo{
+			Hardware: types.VirtualHardware{Device: []types.BaseVirtualDevice{
+				iso(3000, local, true), iso(3001, local, false), iso(3002, shared, true), disk,
+			}},
+			KeyId: &types.CryptoKeyId{ProviderId: &types.KeyProviderId{Id: "kms"}},
+		},
+	}
+	dss := map[types.ManagedObjectReference]bool{local: true, shared: false}
+
+	got := preflightConfig{Block: []string{checkSnapshots}}.ruleFindings(moVM, dss)
+
+	want := []finding{
+		{Check: checkSnapshots, Severity: severityBlocking, Message: "the vm has 2 snapshot(s), which move with it"},
+		{Check: checkMedia, Severity: severityBlocking, Message: "device 3000 is connected to [ds] os.iso on local datastore ds-local"},
+		{Check: checkIndependentDisks, Severity: severityWarning, Message: "disk 2000 is independent_persistent, so it isn't in snapshots"},
+		{Check: checkEncryption, Severity: severityWarning, Message: "the vm is encrypted, the target must use key provider kms"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ruleFindings() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPreflightConfigValidate(t *testing.T) {
+	if err := (preflightConfig{Warn: []string{checkMedia}}).validate(); err != nil {
+		t.Errorf("validate() error = %v, want nil", err)
+	}
+
+	if err := (preflightConfig{Block: []string{checkRelocate}}).validate(); err == nil {
+		t.Error("validate() error = nil, want an error for a check that can't be configured")
+	}
+}
@@ -0,0 +1,128 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// staleError refuses a relocation that no longer fits the VM, as the VM or
+// the target datastore changed while the relocation waited for approval or
+// in the queue.
+type staleError struct {
+	outcome string
+	reason  string
+}
+
+func (e *staleError) Error() string {
+	return e.reason
+}
+
+// recheck checks that a relocation that waited can still be made: automation
+// is still allowed for the VM, the VM is still on the datastores it was
+// planned from, and the target datastore still has the free space planned
+// for. If not, it returns a *staleError. The pre-flight checks run when the
+// relocation is made.
+func (r relocation) recheck(ctx context.Context, cfg *vcConfig, clt *vsClient) (err error) {
+	ctx, span := startSpan(ctx, "relocation.Recheck", vmMoRef(r.VM.Value))
+	defer func() { endSpan(span, err) }()
+
+	if cfg.Automation.enabled() {
+		allowed, reason, err := clt.automationAllowed(ctx, cfg.Automation, r.VM)
+		if err != nil {
+			return fmt.Errorf("checking automation setting: %w", err)
+		}
+
+		if !allowed {
+			return &staleError{outcome: outcomeOptedOut, reason: reason}
+		}
+	}
+
+	// Relocations planned before the datastores were recorded are not
+	// checked against them.
+	if r.From != nil {
+		current, err := vmPlacement(ctx, object.NewVirtualMachine(clt.govmomi.Client, r.VM))
+		if err != nil {
+			return fmt.Errorf("getting vm placement: %w", err)
+		}
+
+		if !sameDatastores(current.Datastores, r.From) {
+			return &staleError{
+				outcome: outcomeStale,
+				reason:  fmt.Sprintf("the vm moved from datastore(s) %s to %s since", refValues(r.From), refValues(current.Datastores)),
+			}
+		}
+	}
+
+	if target := r.target(); target != nil && r.Need > 0 {
+		dss, err := clt.datastores(ctx, []types.ManagedObjectReference{*target})
+		if err != nil {
+			return err
+		}
+
+		if len(dss) == 1 && dss[0].Summary.FreeSpace < r.Need {
+			return &staleError{
+				outcome: outcomeNoPlacement,
+				reason:  fmt.Sprintf("datastore %s has %d bytes free, %d needed", target.Value, dss[0].Summary.FreeSpace, r.Need),
+			}
+		}
+	}
+
+	return nil
+}
+
+// target returns the datastore the VM or its disks move to, if the
+// relocation names one.
+func (r relocation) target() *types.ManagedObjectReference {
+	if r.Spec.Datastore != nil {
+		return r.Spec.Datastore
+	}
+
+	if len(r.Disks) > 0 {
+		return &r.Disks[0].Datastore
+	}
+
+	return nil
+}
+
+// refuse records in the audit trail that the relocation was not made.
+func (r relocation) refuse(ctx context.Context, cfg *vcConfig, clt *vsClient, reason string) {
+	rec := r.auditRecord(cfg.VCenter.Server)
+	rec.Result = resultRefused
+	rec.Reason = reason
+	newAuditor(cfg.Audit, clt.govmomi.Client).record(ctx, rec)
+}
+
+// sameDatastores reports whether a and b hold the same datastores, in any
+// order.
+func sameDatastores(a, b []types.ManagedObjectReference) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	seen := make(map[types.ManagedObjectReference]int)
+	for _, ds := range a {
+		seen[ds]++
+	}
+	for _, ds := range b {
+		if seen[ds] == 0 {
+			return false
+		}
+		seen[ds]--
+	}
+
+	return true
+}
+
+// refValues joins the IDs of refs.
+func refValues(refs []types.ManagedObjectReference) string {
+	var values []string
+	for _, ref := range refs {
+		values = append(values, ref.Value)
+	}
+
+	return strings.Join(values, ", ")
+}
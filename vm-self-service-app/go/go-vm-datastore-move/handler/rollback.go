@@ -0,0 +1,152 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// step is a change made to vSphere, and how to undo it.
+type step struct {
+	name string
+	undo func(context.Context) error
+}
+
+// transaction records the steps of a change as they are made, so that a
+// change failing part way can be rolled back.
+type transaction struct {
+	steps []step
+}
+
+// done records a step that was made.
+func (tx *transaction) done(name string, undo func(context.Context) error) {
+	tx.steps = append(tx.steps, step{name: name, undo: undo})
+}
+
+// rollback undoes the steps made, last first. It carries on past the steps it
+// can't undo, and returns an error naming them.
+func (tx *transaction) rollback(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "transaction.Rollback")
+	defer func() { endSpan(span, err) }()
+
+	var failed []string
+	for i := len(tx.steps) - 1; i >= 0; i-- {
+		s := tx.steps[i]
+		if err := s.undo(ctx); err != nil {
+			logger(ctx).Error("undoing step", "step", s.name, "error", err)
+			failed = append(failed, fmt.Sprintf("%s: %v", s.name, err))
+			continue
+		}
+
+		logger(ctx).Info("undid step", "step", s.name)
+	}
+	tx.steps = nil
+
+	if len(failed) > 0 {
+		return fmt.Errorf("undoing %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// vmLayout is the datastore of each of the VM's files: its home, and its
+// disks by key.
+type vmLayout struct {
+	Home  *types.ManagedObjectReference
+	Disks map[int32]types.ManagedObjectReference
+}
+
+// vmLayout returns where the VM's files are.
+func (clt *vsClient) vmLayout(ctx context.Context, vm types.ManagedObjectReference) (vmLayout, error) {
+	pc := property.DefaultCollector(clt.govmomi.Client)
+
+	var moVM mo.VirtualMachine
+	if err := timedRetrieve(func() error {
+		return pc.RetrieveOne(ctx, vm, []string{"datastore", "config.files", "config.hardware.device"}, &moVM)
+	}); err != nil {
+		return vmLayout{}, fmt.Errorf("retrieving vm: %w", err)
+	}
+
+	l := vmLayout{Disks: make(map[int32]types.ManagedObjectReference)}
+	for _, d := range vmDisks(moVM) {
+		l.Disks[d.disk.Key] = d.datastore
+	}
+
+	var home object.DatastorePath
+	if moVM.Config == nil || !home.FromString(moVM.Config.Files.VmPathName) || len(moVM.Datastore) == 0 {
+		return l, nil
+	}
+
+	var dss []mo.Datastore
+	if err := timedRetrieve(func() error {
+		return pc.Retrieve(ctx, moVM.Datastore, []string{"name"}, &dss)
+	}); err != nil {
+		return vmLayout{}, fmt.Errorf("retrieving datastores: %w", err)
+	}
+
+	for _, ds := range dss {
+		if ds.Name == home.Datastore {
+			ref := ds.Self
+			l.Home = &ref
+		}
+	}
+
+	return l, nil
+}
+
+// undoSpec returns the relocate spec that moves the files of the VM that
+// moved from before back to where they were, or false if none moved.
+func undoSpec(before, current vmLayout) (types.VirtualMachineRelocateSpec, bool) {
+	var spec types.VirtualMachineRelocateSpec
+	moved := false
+
+	if before.Home != nil && (current.Home == nil || *current.Home != *before.Home) {
+		spec.Datastore = before.Home
+		moved = true
+	}
+
+	for key, ds := range current.Disks {
+		if was, ok := before.Disks[key]; ok && was != ds {
+			spec.Disk = append(spec.Disk, types.VirtualMachineRelocateSpecDiskLocator{DiskId: key, Datastore: was})
+			moved = true
+		}
+	}
+
+	sort.Slice(spec.Disk, func(i, j int) bool {
+		return spec.Disk[i].DiskId < spec.Disk[j].DiskId
+	})
+
+	return spec, moved
+}
+
+// moveBack relocates the files of the VM that moved since before back to
+// where they were, and waits for it.
+func (clt *vsClient) moveBack(ctx context.Context, vm types.ManagedObjectReference, before vmLayout) error {
+	current, err := clt.vmLayout(ctx, vm)
+	if err != nil {
+		return err
+	}
+
+	spec, moved := undoSpec(before, current)
+	if !moved {
+		return nil
+	}
+
+	ctx, span := startSpan(ctx, "VirtualMachine.Relocate", vmMoRef(vm.Value))
+	task, err := object.NewVirtualMachine(clt.govmomi.Client, vm).Relocate(ctx, spec, types.VirtualMachineMovePriorityHighPriority)
+	if err == nil {
+		err = task.Wait(ctx)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("moving vm back: %w", err)
+	}
+
+	return nil
+}
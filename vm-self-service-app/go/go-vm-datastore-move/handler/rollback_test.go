@@ -0,0 +1,79 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	var undone []string
+	undo := func(name string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			undone = append(undone, name)
+			return err
+		}
+	}
+
+	tx := &transaction{}
+	tx.done("first", undo("first", nil))
+	tx.done("second", undo("second", errors.New("gone")))
+	tx.done("third", undo("third", nil))
+
+	err := tx.rollback(context.Background())
+	if want := "undoing second: gone"; err == nil || err.Error() != want {
+		t.Errorf("rollback() error = %v, want %q", err, want)
+	}
+
+	if want := []string{"third", "second", "first"}; !reflect.DeepEqual(undone, want) {
+		t.Errorf("undone = %v, want %v", undone, want)
+	}
+
+	undone = nil
+	if err := tx.rollback(context.Background()); err != nil || len(undone) > 0 {
+		t.Errorf("second rollback() undid %v, error %v, want nothing", undone, err)
+	}
+}
+
+func TestUndoSpec(t *testing.T) {
+	ds1 := types.ManagedObjectReference{Type: "Datastore", Value: "ds-1"}
+	ds2 := types.ManagedObjectReference{Type: "Datastore", Value: "ds-2"}
+
+	before := vmLayout{Home: &ds1, Disks: map[int32]types.ManagedObjectReference{2000: ds1, 2001: ds1, 2002: ds2}}
+
+	if _, moved := undoSpec(before, before); moved {
+		t.Error("undoSpec() moved = true for a vm that didn't move")
+	}
+
+	// The home and the first disk moved before the relocation failed.
+	current := vmLayout{Home: &ds2, Disks: map[int32]types.ManagedObjectReference{2000: ds2, 2001: ds1, 2002: ds2}}
+
+	spec, moved := undoSpec(before, current)
+	want := types.VirtualMachineRelocateSpec{
+		Datastore: &ds1,
+		Disk:      []types.VirtualMachineRelocateSpecDiskLocator{{DiskId: 2000, Datastore: ds1}},
+	}
+	if !moved || !reflect.DeepEqual(spec, want) {
+		t.Errorf("undoSpec() = %+v, %t, want %+v, true", spec, moved, want)
+	}
+}
+
+func TestEventSeverity(t *testing.T) {
+	tests := []struct {
+		result, want string
+	}{
+		{resultSuccess, "info"},
+		{resultFailure, "warning"},
+		{resultRolledBack, "warning"},
+		{resultRollbackFailed, "error"},
+	}
+
+	for _, tc := range tests {
+		if got := eventSeverity(tc.result); got != tc.want {
+			t.Errorf("eventSeverity(%s) = %q, want %q", tc.result, got, tc.want)
+		}
+	}
+}
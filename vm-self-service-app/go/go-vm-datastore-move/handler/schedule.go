@@ -0,0 +1,487 @@
+package function
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	handler "github.com/openfaas/templates-sdk/go-http"
+	"github.com/vmware/govmomi/object"
+)
+
+// now is the clock the schedule is checked against.
+var now = time.Now
+
+// What is done with changes outside the maintenance windows.
+const (
+	outOfWindowRecord = "record"
+	outOfWindowQueue  = "queue"
+)
+
+// scheduleConfig is the optional [schedule] section of vcconfig. Changes are
+// only made inside a maintenance window and outside blackout dates.
+type scheduleConfig struct {
+	// Timezone the windows and blackouts are in, e.g. "Europe/Berlin".
+	// Defaults to UTC.
+	Timezone string `toml:"timezone"`
+	// Windows are cron expressions (minute hour day-of-month month
+	// day-of-week). Changes can be made in any minute one of them matches,
+	// or at any time outside blackouts if there are none.
+	Windows []string `toml:"windows"`
+	// Blackouts are dates (2026-12-24) or inclusive date ranges
+	// (2026-12-20..2027-01-05) when no change is made.
+	Blackouts []string `toml:"blackouts"`
+	// OutOfWindow is "queue" to make the change in the next window, or
+	// "record" to only record it. Defaults to record.
+	OutOfWindow string `toml:"out_of_window"`
+	// Queue is the directory queued changes are saved in.
+	Queue string `toml:"queue"`
+}
+
+func (c scheduleConfig) enabled() bool {
+	return len(c.Windows) > 0 || len(c.Blackouts) > 0
+}
+
+func (c scheduleConfig) validate() error {
+	switch c.OutOfWindow {
+	case "", outOfWindowRecord:
+	case outOfWindowQueue:
+		if c.Queue == "" {
+			return errors.New("required field(s) missing, including schedule queue")
+		}
+	default:
+		return fmt.Errorf("schedule out_of_window must be %s or %s", outOfWindowRecord, outOfWindowQueue)
+	}
+
+	_, err := c.parse()
+
+	return err
+}
+
+// schedule is a parsed scheduleConfig.
+type schedule struct {
+	loc       *time.Location
+	windows   []cronExpr
+	blackouts []blackout
+}
+
+// blackout is the time from the start of its first date to the end of its last.
+type blackout struct {
+	name       string
+	start, end time.Time
+}
+
+func (c scheduleConfig) parse() (*schedule, error) {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("loading schedule timezone: %w", err)
+	}
+
+	s := schedule{loc: loc}
+
+	for _, w := range c.Windows {
+		expr, err := parseCron(w)
+		if err != nil {
+			return nil, fmt.Errorf("parsing schedule window %q: %w", w, err)
+		}
+		s.windows = append(s.windows, expr)
+	}
+
+	for _, b := range c.Blackouts {
+		first, last := b, b
+		if i := strings.Index(b, ".."); i >= 0 {
+			first, last = b[:i], b[i+2:]
+		}
+
+		start, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(first), loc)
+		if err != nil {
+			return nil, fmt.Errorf("parsing schedule blackout %q: %w", b, err)
+		}
+
+		end, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(last), loc)
+		if err != nil {
+			return nil, fmt.Errorf("parsing schedule blackout %q: %w", b, err)
+		}
+
+		s.blackouts = append(s.blackouts, blackout{name: b, start: start, end: end.AddDate(0, 0, 1)})
+	}
+
+	return &s, nil
+}
+
+// allows reports whether a change can be made at t, and if not, why.
+func (s *schedule) allows(t time.Time) (bool, string) {
+	t = t.In(s.loc)
+
+	for _, b := range s.blackouts {
+		if !t.Before(b.start) && t.Before(b.end) {
+			return false, "change freeze " + b.name
+		}
+	}
+
+	if len(s.windows) == 0 {
+		return true, ""
+	}
+
+	for _, w := range s.windows {
+		if w.matches(t) {
+			return true, ""
+		}
+	}
+
+	return false, "outside maintenance windows"
+}
+
+// next returns the start of the first minute after t in which a change can be
+// made, or the zero time if there is none within a year.
+func (s *schedule) next(t time.Time) time.Time {
+	t = t.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+
+	for end := t.AddDate(1, 0, 0); t.Before(end); t = t.Add(time.Minute) {
+		if ok, _ := s.allows(t); ok {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// cronExpr is a standard five field cron expression. Each field is a bitset
+// of the values it matches.
+type cronExpr struct {
+	minute, hour, dom, month, dow uint64
+	// Like cron, when both day fields are restricted a day matching either is
+	// matched.
+	domAny, dowAny bool
+}
+
+func parseCron(s string) (cronExpr, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return cronExpr{}, fmt.Errorf("want 5 fields, got %d", len(fields))
+	}
+
+	var (
+		c   cronExpr
+		err error
+	)
+
+	bounds := []struct {
+		field    *uint64
+		min, max int
+	}{
+		{&c.minute, 0, 59},
+		{&c.hour, 0, 23},
+		{&c.dom, 1, 31},
+		{&c.month, 1, 12},
+		{&c.dow, 0, 7},
+	}
+
+	for i, b := range bounds {
+		if *b.field, err = parseCronField(fields[i], b.min, b.max); err != nil {
+			return cronExpr{}, err
+		}
+	}
+
+	// Sunday is both 0 and 7.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of *, n or n-m, each
+// optionally followed by /step.
+func parseCronField(s string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, item := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+			step, item = n, item[:i]
+		}
+
+		lo, hi := min, max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", item)
+			}
+
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", item)
+				}
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", item, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (c cronExpr) matches(t time.Time) bool {
+	has := func(bits uint64, v int) bool { return bits&(1<<uint(v)) != 0 }
+
+	if !has(c.minute, t.Minute()) || !has(c.hour, t.Hour()) || !has(c.month, int(t.Month())) {
+		return false
+	}
+
+	dom, dow := has(c.dom, t.Day()), has(c.dow, int(t.Weekday()))
+	if !c.domAny && !c.dowAny {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+// queuedAction is a change waiting for the next maintenance window.
+type queuedAction struct {
+	ID     string     `json:"id"`
+	Queued time.Time  `json:"queued"`
+	Next   time.Time  `json:"next"`
+	Change relocation `json:"change"`
+}
+
+// carryOut makes the change if the schedule allows it now. Otherwise the
+// change is queued or only recorded. It returns the relocation task, if one
+// was started, the response message and the event outcome.
+func carryOut(ctx context.Context, cfg *vcConfig, clt *vsClient, change relocation) (*object.Task, string, string, error) {
+	if cfg.Schedule.enabled() {
+		sched, err := cfg.Schedule.parse()
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		if ok, reason := sched.allows(now()); !ok {
+			message, outcome, err := deferChange(ctx, cfg, clt, sched, change, reason)
+			return nil, message, outcome, err
+		}
+	}
+
+	task, warned, err := change.apply(ctx, cfg, clt)
+
+	var blocked *preflightError
+	if errors.As(err, &blocked) {
+		change.refuse(ctx, cfg, clt, blocked.Error())
+
+		return nil, fmt.Sprintf("Not relocating %s, %s.", change.VM.Value, blocked), outcomeBlocked, nil
+	}
+
+	var refusal *limitError
+	if errors.As(err, &refusal) {
+		change.refuse(ctx, cfg, clt, refusal.reason)
+
+		return nil, fmt.Sprintf("Not relocating %s, %s.", change.VM.Value, refusal.reason), outcomeRateLimited, nil
+	}
+
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return task, relocatedMessage(task, change.Policies, warned), outcomeActed, nil
+}
+
+// deferChange queues or records a change that is out of window.
+func deferChange(ctx context.Context, cfg *vcConfig, clt *vsClient, sched *schedule, change relocation, reason string) (string, string, error) {
+	audit := newAuditor(cfg.Audit, clt.govmomi.Client)
+	rec := change.auditRecord(cfg.VCenter.Server)
+	rec.Reason = reason
+
+	if cfg.Schedule.OutOfWindow != outOfWindowQueue {
+		rec.Result = resultSkipped
+		audit.record(ctx, rec)
+
+		return fmt.Sprintf("Not relocating %s, %s.", change.VM.Value, reason), outcomeOutOfWindow, nil
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return "", "", err
+	}
+
+	q := queuedAction{
+		ID:     id,
+		Queued: now().UTC(),
+		Next:   sched.next(now()),
+		Change: change,
+	}
+
+	store := actionStore(cfg.Schedule.Queue)
+	if err := store.save(q.ID, q); err != nil {
+		return "", "", fmt.Errorf("queueing change: %w", err)
+	}
+
+	// The new relocation replaces those queued earlier for the VM, as it is
+	// planned from where the VM is now.
+	for _, old := range loadQueue(ctx, store) {
+		if old.ID == q.ID || old.Change.VM != change.VM {
+			continue
+		}
+
+		if store.remove(old.ID) != nil {
+			continue
+		}
+
+		superseded := old.Change.auditRecord(cfg.VCenter.Server)
+		superseded.Result = resultSkipped
+		superseded.Reason = "superseded by event " + change.EventID
+		audit.record(ctx, superseded)
+
+		logger(ctx).Info("replaced queued change", "queued", old.ID, "event_id", old.Change.EventID)
+	}
+
+	rec.Result = resultQueued
+	audit.record(ctx, rec)
+
+	return fmt.Sprintf("Queued relocating %s until %s, %s.", change.VM.Value, q.Next.Format(time.RFC3339), reason), outcomeQueued, nil
+}
+
+// loadQueue returns the queued changes, oldest first.
+func loadQueue(ctx context.Context, store actionStore) []queuedAction {
+	var queue []queuedAction
+	for _, id := range store.ids() {
+		var q queuedAction
+		if err := store.load(id, &q); err != nil {
+			logger(ctx).Error("loading queued change", "queued", id, "error", err)
+			continue
+		}
+		q.ID = id
+
+		queue = append(queue, q)
+	}
+
+	sort.Slice(queue, func(i, j int) bool {
+		if !queue[i].Queued.Equal(queue[j].Queued) {
+			return queue[i].Queued.Before(queue[j].Queued)
+		}
+
+		return queue[i].ID < queue[j].ID
+	})
+
+	return queue
+}
+
+// runQueue makes the queued changes, oldest first, if the schedule allows it
+// now. It answers invocations with an empty body, so the OpenFaaS
+// cron-connector can run it every few minutes.
+func runQueue(req handler.Request) (handler.Response, error) {
+	initTracing()
+	ctx, span := startSpan(traceContext(req.Header), "RunQueue")
+	defer flushTraces(ctx)
+	defer span.End()
+	ctx = withLogger(ctx, baseLogger)
+
+	cfg, err := loadTomlCfg(secretPath("vcconfig"))
+	if err != nil {
+		return errRespondAndLog(ctx, fmt.Errorf("loading of vcconfig: %w", err))
+	}
+
+	if cfg.Schedule.OutOfWindow != outOfWindowQueue {
+		return respond(ctx, http.StatusOK, "No queue configured.")
+	}
+
+	sched, err := cfg.Schedule.parse()
+	if err != nil {
+		return errRespondAndLog(ctx, err)
+	}
+
+	if ok, reason := sched.allows(now()); !ok {
+		return respond(ctx, http.StatusOK, "Not running queued changes, "+reason+".")
+	}
+
+	store := actionStore(cfg.Schedule.Queue)
+	queue := loadQueue(ctx, store)
+	if len(queue) == 0 {
+		return respond(ctx, http.StatusOK, "Nothing queued.")
+	}
+
+	start := time.Now()
+	loginCtx, loginSpan := startSpan(ctx, "vcenter.login")
+	vsClt, err := newClient(loginCtx, cfg)
+	endSpan(loginSpan, err)
+	if err != nil {
+		return errRespondAndLog(ctx, fmt.Errorf("connecting to vSphere: %w", err))
+	}
+	observeSince(loginDuration, start)
+
+	done := 0
+	for i, q := range queue {
+		id := q.ID
+
+		// Removing the queued change claims it, so it is made at most once.
+		if store.remove(id) != nil {
+			continue
+		}
+
+		change := q.Change
+		qctx := withLogger(ctx, logger(ctx).With("queued", id, "event_id", change.EventID, "vm", change.VM.Value))
+
+		// The VM may have moved while the relocation was queued.
+		err := change.recheck(qctx, cfg, vsClt)
+		var stale *staleError
+		if errors.As(err, &stale) {
+			change.refuse(qctx, cfg, vsClt, stale.reason)
+			logger(qctx).Info("dropped queued change", "reason", stale.reason)
+			countEvent(change.Alarm, change.Action, stale.outcome)
+			continue
+		}
+		if err == nil {
+			_, _, err = change.apply(qctx, cfg, vsClt)
+		}
+
+		// The rest of the queue waits for the next run once a limit is hit.
+		var refusal *limitError
+		if errors.As(err, &refusal) {
+			if err := store.save(id, q); err != nil {
+				logger(qctx).Error("requeueing change", "error", err)
+			}
+			logger(ctx).Info("queue run stopped by limit", "reason", refusal.reason, "remaining", len(queue)-i)
+			break
+		}
+
+		var blocked *preflightError
+		if errors.As(err, &blocked) {
+			change.refuse(qctx, cfg, vsClt, blocked.Error())
+
+			logger(qctx).Info("queued change blocked", "reason", blocked.Error())
+			countEvent(change.Alarm, change.Action, outcomeBlocked)
+			continue
+		}
+
+		if err != nil {
+			logger(qctx).Error("making queued change", "error", err)
+			countEvent(change.Alarm, change.Action, outcomeFailed)
+			continue
+		}
+
+		logger(qctx).Info("made queued change", "alarm", change.Alarm, "action", change.Action)
+		countEvent(change.Alarm, change.Action, outcomeActed)
+		done++
+	}
+
+	return respond(ctx, http.StatusOK, fmt.Sprintf("Made %d of %d queued change(s).", done, len(queue)))
+}
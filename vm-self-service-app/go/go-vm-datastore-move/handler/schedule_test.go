@@ -0,0 +1,108 @@
+package function
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScheduleAllows(t *testing.T) {
+	cfg := scheduleConfig{
+		Timezone:  "Europe/Berlin",
+		Windows:   []string{"* 22-23,0-5 * * 1-5", "* * * * 6,7"},
+		Blackouts: []string{"2026-12-24..2026-12-26", "2026-11-27"},
+	}
+
+	sched, err := cfg.parse()
+	if err != nil {
+		t.Fatalf("parsing schedule: %v", err)
+	}
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"weekday night", time.Date(2026, 10, 20, 23, 30, 0, 0, berlin), true},
+		{"weekday early morning", time.Date(2026, 10, 21, 5, 59, 0, 0, berlin), true},
+		{"weekday business hours", time.Date(2026, 10, 20, 14, 0, 0, 0, berlin), false},
+		{"weekday night in utc", time.Date(2026, 10, 20, 21, 30, 0, 0, time.UTC), true},
+		{"saturday", time.Date(2026, 10, 24, 14, 0, 0, 0, berlin), true},
+		{"sunday as 7", time.Date(2026, 10, 25, 14, 0, 0, 0, berlin), true},
+		{"blackout date", time.Date(2026, 11, 27, 23, 0, 0, 0, berlin), false},
+		{"blackout range", time.Date(2026, 12, 26, 23, 59, 0, 0, berlin), false},
+		{"after blackout range", time.Date(2026, 12, 27, 0, 0, 0, 0, berlin), true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, reason := sched.allows(tc.t); got != tc.want {
+				t.Errorf("allows(%v) = %v (%s), want %v", tc.t, got, reason, tc.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	sched, err := scheduleConfig{Windows: []string{"*/15 22 * * *"}}.parse()
+	if err != nil {
+		t.Fatalf("parsing schedule: %v", err)
+	}
+
+	got := sched.next(time.Date(2026, 10, 20, 14, 7, 30, 0, time.UTC))
+	if want := time.Date(2026, 10, 20, 22, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("next = %v, want %v", got, want)
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  scheduleConfig
+	}{
+		{"too few fields", scheduleConfig{Windows: []string{"* * * *"}}},
+		{"hour out of range", scheduleConfig{Windows: []string{"* 24 * * *"}}},
+		{"bad step", scheduleConfig{Windows: []string{"*/0 * * * *"}}},
+		{"bad blackout", scheduleConfig{Blackouts: []string{"24.12.2026"}}},
+		{"bad timezone", scheduleConfig{Timezone: "Mars/Olympus", Windows: []string{"* * * * *"}}},
+		{"queue without dir", scheduleConfig{Windows: []string{"* * * * *"}, OutOfWindow: outOfWindowQueue}},
+		{"unknown out_of_window", scheduleConfig{Windows: []string{"* * * * *"}, OutOfWindow: "drop"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.cfg.validate(); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestLoadQueueOldestFirst(t *testing.T) {
+	store := actionStore(t.TempDir())
+	at := time.Date(2026, 10, 20, 14, 0, 0, 0, time.UTC)
+
+	// The IDs are random, so they sort differently from the queued times.
+	for id, queued := range map[string]time.Time{
+		"aa": at.Add(2 * time.Minute),
+		"bb": at,
+		"cc": at.Add(time.Minute),
+		"dd": at,
+	} {
+		if err := store.save(id, queuedAction{ID: id, Queued: queued}); err != nil {
+			t.Fatalf("saving %s: %v", id, err)
+		}
+	}
+
+	var got []string
+	for _, q := range loadQueue(context.Background(), store) {
+		got = append(got, q.ID)
+	}
+
+	if want := []string{"bb", "dd", "cc", "aa"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("loadQueue() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+package function
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// syncedHeader is the first line of the files sync-shared.sh copies from the
+// tagger, naming the file copied.
+var syncedHeader = regexp.MustCompile(`^// Code generated by sync-shared.sh from (\S+)\. DO NOT EDIT\.\n\n`)
+
+// TestSharedFilesInSync checks the files copied from the tagger are the same
+// as the tagger's. The tagger is only next to this handler in the repository,
+// not in the function's build, where the check is skipped.
+func TestSharedFilesInSync(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatalf("listing files: %v", err)
+	}
+
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("reading %s: %v", f, err)
+		}
+
+		m := syncedHeader.FindSubmatch(b)
+		if m == nil {
+			continue
+		}
+
+		src := filepath.Join("..", "..", string(m[1]))
+		want, err := ioutil.ReadFile(src)
+		if os.IsNotExist(err) {
+			t.Skipf("%s is not here to compare with", src)
+		}
+		if err != nil {
+			t.Fatalf("reading %s: %v", src, err)
+		}
+
+		if !bytes.Equal(b[len(m[0]):], want) {
+			t.Errorf("%s differs from %s, run go generate", f, src)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package function
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/pbm"
+	pbmmethods "github.com/vmware/govmomi/pbm/methods"
+	pbmtypes "github.com/vmware/govmomi/pbm/types"
+	"github.com/vmware/govmomi/simulator"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// simEnv is an in-process vCenter (vcsim) that Handle is pointed at through
+// a temporary vcconfig.
+type simEnv struct {
+	t       *testing.T
+	ctx     context.Context
+	client  *govmomi.Client
+	service *simulator.Service
+	vm      types.ManagedObjectReference
+
+	server, user, pass string
+}
+
+// newSimEnv starts vcsim with the default VPX inventory and writes a vcconfig
+// for it. Everything is torn down when the test ends.
+func newSimEnv(t *testing.T) *simEnv {
+	t.Helper()
+	ctx := context.Background()
+
+	model := simulator.VPX()
+	if err := model.Create(); err != nil {
+		t.Fatalf("creating simulator model: %v", err)
+	}
+
+	model.Service.TLS = new(tls.Config)
+	server := model.Service.NewServer()
+	t.Cleanup(func() {
+		server.Close()
+		model.Remove()
+	})
+
+	gc, err := govmomi.NewClient(ctx, server.URL, true)
+	if err != nil {
+		t.Fatalf("connecting to simulator: %v", err)
+	}
+
+	env := &simEnv{
+		t:       t,
+		ctx:     ctx,
+		client:  gc,
+		service: model.Service,
+		vm:      simulator.Map.Any("VirtualMachine").Reference(),
+	}
+
+	env.server = server.URL.Host
+	env.user = server.URL.User.Username()
+	env.pass, _ = server.URL.User.Password()
+	env.writeConfig(env.server, env.user, env.pass)
+	env.provisioningChecker(types.CheckResult{})
+
+	return env
+}
+
+// writeConfig writes a vcconfig.toml, followed by any extra sections, and
+// points secret_mount_path at it.
+func (e *simEnv) writeConfig(server, user, pass string, extra ...string) {
+	e.t.Helper()
+
+	dir, err := ioutil.TempDir("", "vcconfig")
+	if err != nil {
+		e.t.Fatalf("creating config dir: %v", err)
+	}
+
+	cfg := fmt.Sprintf("[vcenter]\nserver = %q\nuser = %q\npassword = %q\ninsecure = true\n", server, user, pass)
+	cfg += strings.Join(extra, "\n")
+	if err := ioutil.WriteFile(filepath.Join(dir, "vcconfig"), []byte(cfg), 0600); err != nil {
+		e.t.Fatalf("writing vcconfig: %v", err)
+	}
+
+	orig, set := os.LookupEnv("secret_mount_path")
+	os.Setenv("secret_mount_path", dir)
+	e.t.Cleanup(func() {
+		if set {
+			os.Setenv("secret_mount_path", orig)
+		} else {
+			os.Unsetenv("secret_mount_path")
+		}
+		os.RemoveAll(dir)
+	})
+}
+
+// relocateTo points Handle's relocation spec at the given inventory objects.
+func (e *simEnv) relocateTo(spec types.VirtualMachineRelocateSpec) {
+	orig := relocSpec
+	relocSpec = func(*vsClient, context.Context, placementConfig, types.ManagedObjectReference, *types.ManagedObjectReference) (placement, error) {
+		return placement{Spec: spec}, nil
+	}
+	e.t.Cleanup(func() { relocSpec = orig })
+}
+
+// otherHost returns a host in the test VM's cluster that it is not running on.
+func (e *simEnv) otherHost() mo.HostSystem {
+	e.t.Helper()
+
+	vm := e.moVM()
+	for _, obj := range simulator.Map.All("HostSystem") {
+		host := obj.(*simulator.HostSystem)
+		if host.Self != *vm.Runtime.Host && host.Parent.Type == "ClusterComputeResource" {
+			return host.HostSystem
+		}
+	}
+
+	e.t.Fatal("no other host in inventory")
+	return mo.HostSystem{}
+}
+
+// addDatastore creates a datastore mounted on every host, with the given
+// free space.
+func (e *simEnv) addDatastore(name string, free int64) types.ManagedObjectReference {
+	e.t.Helper()
+
+	dir := e.t.TempDir()
+	var ref types.ManagedObjectReference
+	for _, obj := range simulator.Map.All("HostSystem") {
+		h := obj.Reference()
+		dss, err := object.NewHostSystem(e.client.Client, h).ConfigManager().DatastoreSystem(e.ctx)
+		if err != nil {
+			e.t.Fatalf("getting datastore system of %s: %v", h.Value, err)
+		}
+
+		ds, err := dss.CreateLocalDatastore(e.ctx, name, dir)
+		if err != nil {
+			e.t.Fatalf("creating datastore %s: %v", name, err)
+		}
+		ref = ds.Reference()
+	}
+
+	// vcsim leaves datastores inaccessible.
+	ds := simulator.Map.Get(ref).(*simulator.Datastore)
+	ds.Summary.Accessible = true
+	ds.Summary.FreeSpace = free
+
+	return ref
+}
+
+// setAttribute sets a custom attribute on an inventory object, defining it
+// if needed.
+func (e *simEnv) setAttribute(ref types.ManagedObjectReference, name, value string) {
+	e.t.Helper()
+
+	m, err := object.GetCustomFieldsManager(e.client.Client)
+	if err != nil {
+		e.t.Fatalf("getting custom fields manager: %v", err)
+	}
+
+	key, err := m.FindKey(e.ctx, name)
+	if err == object.ErrKeyNameNotFound {
+		var def *types.CustomFieldDef
+		def, err = m.Add(e.ctx, name, "", nil, nil)
+		if def != nil {
+			key = def.Key
+		}
+	}
+	if err != nil {
+		e.t.Fatalf("defining attribute %s: %v", name, err)
+	}
+
+	if err := m.Set(e.ctx, ref, key, value); err != nil {
+		e.t.Fatalf("setting attribute %s on %s: %v", name, ref.Value, err)
+	}
+}
+
+// datastoreCluster puts the datastores into a new datastore cluster, with
+// Storage DRS enabled, and returns it.
+func (e *simEnv) datastoreCluster(name string, dss ...types.ManagedObjectReference) types.ManagedObjectReference {
+	e.t.Helper()
+
+	folders, err := object.NewDatacenter(e.client.Client, simulator.Map.Any("Datacenter").Reference()).Folders(e.ctx)
+	if err != nil {
+		e.t.Fatalf("getting datacenter folders: %v", err)
+	}
+
+	pod, err := folders.DatastoreFolder.CreateStoragePod(e.ctx, name)
+	if err != nil {
+		e.t.Fatalf("creating datastore cluster %s: %v", name, err)
+	}
+
+	simPod := simulator.Map.Get(pod.Reference()).(*simulator.StoragePod)
+	simPod.PodStorageDrsEntry = &types.PodStorageDrsEntry{
+		StorageDrsConfig: types.StorageDrsConfigInfo{
+			PodConfig: types.StorageDrsPodConfigInfo{Enabled: true},
+		},
+	}
+
+	for _, ref := range dss {
+		ds := simulator.Map.Get(ref).(*simulator.Datastore)
+		ds.Parent = &simPod.Self
+		simPod.ChildEntity = append(simPod.ChildEntity, ref)
+	}
+
+	return pod.Reference()
+}
+
+// storageDRS stands in for Storage DRS, recommending relocating with spec.
+// The recommendation requests it received are sent on the returned channel.
+func (e *simEnv) storageDRS(spec types.VirtualMachineRelocateSpec) <-chan types.StoragePlacementSpec {
+	requests := make(chan types.StoragePlacementSpec, 1)
+	simulator.Map.Put(&storageResourceManager{
+		StorageResourceManager: mo.StorageResourceManager{Self: *e.client.ServiceContent.StorageResourceManager},
+		spec:                   spec,
+		requests:               requests,
+	})
+
+	return requests
+}
+
+// storageResourceManager is a vcsim StorageResourceManager, which vcsim
+// lacks, that recommends a fixed relocation.
+type storageResourceManager struct {
+	mo.StorageResourceManager
+
+	spec     types.VirtualMachineRelocateSpec
+	requests chan types.StoragePlacementSpec
+}
+
+func (m *storageResourceManager) RecommendDatastores(req *types.RecommendDatastores) soap.HasFault {
+	m.requests <- req.StorageSpec
+
+	return &methods.RecommendDatastoresBody{
+		Res: &types.RecommendDatastoresResponse{
+			Returnval: types.StoragePlacementResult{
+				Recommendations: []types.ClusterRecommendation{{
+					Key:    "1",
+					Rating: 5,
+					Action: []types.BaseClusterAction{&types.StoragePlacementAction{
+						ClusterAction: types.ClusterAction{Type: "StoragePlacementV1"},
+						Vm:            req.StorageSpec.Vm,
+						RelocateSpec:  m.spec,
+						Destination:   *m.spec.Datastore,
+					}},
+				}},
+			},
+		},
+	}
+}
+
+// provisioningChecker makes the relocation checks of vCenter return result.
+func (e *simEnv) provisioningChecker(result types.CheckResult) {
+	simulator.Map.Put(&vmProvisioningChecker{
+		VirtualMachineProvisioningChecker: mo.VirtualMachineProvisioningChecker{Self: *e.client.ServiceContent.VmProvisioningChecker},
+		result:                            result,
+	})
+}
+
+// vmProvisioningChecker is a vcsim VirtualMachineProvisioningChecker, which
+// vcsim lacks, with a fixed relocation check result.
+type vmProvisioningChecker struct {
+	mo.VirtualMachineProvisioningChecker
+
+	result types.CheckResult
+}
+
+func (c *vmProvisioningChecker) CheckRelocateTask(ctx *simulator.Context, req *types.CheckRelocate_Task) soap.HasFault {
+	task := simulator.CreateTask(c, "checkRelocate", func(*simulator.Task) (types.AnyType, types.BaseMethodFault) {
+		result := c.result
+		result.Vm = &req.Vm
+
+		return types.ArrayOfCheckResult{CheckResult: []types.CheckResult{result}}, nil
+	})
+
+	return &methods.CheckRelocate_TaskBody{
+		Res: &types.CheckRelocate_TaskResponse{Returnval: task.Run(ctx)},
+	}
+}
+
+// storagePolicies stands in for the storage policy (PBM) service, which vcsim
+// lacks. names maps policy IDs to names, associated the keys of VMs and disks
+// ("vm-1:2000") to their policy ID, and compatible policy IDs to the
+// datastores compatible with them.
+func (e *simEnv) storagePolicies(names, associated map[string]string, compatible map[string][]types.ManagedObjectReference) {
+	r := simulator.NewRegistry()
+	r.Namespace = pbm.Namespace
+	r.Path = pbm.Path
+
+	svc := &pbmService{names: names, associated: associated, compatible: compatible}
+	for _, ref := range []types.ManagedObjectReference{
+		pbm.ServiceInstance,
+		{Type: "PbmProfileProfileManager", Value: "ProfileManager"},
+		{Type: "PbmPlacementSolver", Value: "PlacementSolver"},
+	} {
+		r.Put(&pbmObject{Self: ref, pbmService: svc})
+	}
+
+	e.service.RegisterSDK(r)
+
+	// vcsim doesn't prefix the policy type with its namespace, as PBM does.
+	types.Add("PbmCapabilityProfile", reflect.TypeOf((*pbmtypes.PbmCapabilityProfile)(nil)).Elem())
+}
+
+// pbmService is the state of the storage policy service stand-in.
+type pbmService struct {
+	names      map[string]string
+	associated map[string]string
+	compatible map[string][]types.ManagedObjectReference
+}
+
+// pbmObject is a managed object of the storage policy service stand-in.
+type pbmObject struct {
+	Self types.ManagedObjectReference
+	*pbmService
+}
+
+func (o *pbmObject) Reference() types.ManagedObjectReference {
+	return o.Self
+}
+
+func (o *pbmObject) PbmRetrieveServiceContent(*pbmtypes.PbmRetrieveServiceContent) soap.HasFault {
+	return &pbmmethods.PbmRetrieveServiceContentBody{
+		Res: &pbmtypes.PbmRetrieveServiceContentResponse{
+			Returnval: pbmtypes.PbmServiceInstanceContent{
+				ProfileManager:  types.ManagedObjectReference{Type: "PbmProfileProfileManager", Value: "ProfileManager"},
+				PlacementSolver: types.ManagedObjectReference{Type: "PbmPlacementSolver", Value: "PlacementSolver"},
+			},
+		},
+	}
+}
+
+func (o *pbmObject) PbmQueryAssociatedProfile(req *pbmtypes.PbmQueryAssociatedProfile) soap.HasFault {
+	res := &pbmtypes.PbmQueryAssociatedProfileResponse{}
+	if id, ok := o.associated[req.Entity.Key]; ok {
+		res.Returnval = []pbmtypes.PbmProfileId{{UniqueId: id}}
+	}
+
+	return &pbmmethods.PbmQueryAssociatedProfileBody{Res: res}
+}
+
+func (o *pbmObject) PbmRetrieveContent(req *pbmtypes.PbmRetrieveContent) soap.HasFault {
+	res := &pbmtypes.PbmRetrieveContentResponse{}
+	for _, id := range req.ProfileIds {
+		res.Returnval = append(res.Returnval, &pbmtypes.PbmCapabilityProfile{
+			PbmProfile: pbmtypes.PbmProfile{ProfileId: id, Name: o.names[id.UniqueId]},
+		})
+	}
+
+	return &pbmmethods.PbmRetrieveContentBody{Res: res}
+}
+
+func (o *pbmObject) PbmCheckRequirements(req *pbmtypes.PbmCheckRequirements) soap.HasFault {
+	id := req.PlacementSubjectRequirement[0].(*pbmtypes.PbmPlacementCapabilityProfileRequirement).ProfileId.UniqueId
+
+	res := &pbmtypes.PbmCheckRequirementsResponse{}
+	for _, hub := range req.HubsToSearch {
+		r := pbmtypes.PbmPlacementCompatibilityResult{Hub: hub}
+		if !containsRef(o.compatible[id], types.ManagedObjectReference{Type: hub.HubType, Value: hub.HubId}) {
+			r.Error = []types.LocalizedMethodFault{{LocalizedMessage: "incompatible"}}
+		}
+		res.Returnval = append(res.Returnval, r)
+	}
+
+	return &pbmmethods.PbmCheckRequirementsBody{Res: res}
+}
+
+// moVM retrieves the test VM's current properties.
+func (e *simEnv) moVM() mo.VirtualMachine {
+	e.t.Helper()
+
+	var vm mo.VirtualMachine
+	if err := e.client.RetrieveOne(e.ctx, e.vm, nil, &vm); err != nil {
+		e.t.Fatalf("retrieving vm: %v", err)
+	}
+
+	return vm
+}
+
+// datastoreAlarmEvent builds a CloudEvent body for an AlarmStatusChangedEvent
+// on a datastore.
+func datastoreAlarmEvent(t *testing.T, alarm, to string, ds types.ManagedObjectReference) []byte {
+	t.Helper()
+
+	var evt cloudEvent
+	evt.Data.Alarm.Name = alarm
+	evt.Data.Alarm.Alarm = types.ManagedObjectReference{Type: "Alarm", Value: "alarm-12"}
+	evt.Data.From = "yellow"
+	evt.Data.To = to
+	evt.Data.Ds = &types.DatastoreEventArgument{Datastore: ds}
+
+	body, err := json.Marshal(evt)
+	if err != nil {
+		t.Fatalf("marshalling event: %v", err)
+	}
+
+	return body
+}
+
+// alarmEvent builds a CloudEvent body for an AlarmStatusChangedEvent.
+func alarmEvent(t *testing.T, alarm, to string, vm types.ManagedObjectReference) []byte {
+	t.Helper()
+
+	var evt cloudEvent
+	evt.Data.Alarm.Name = alarm
+	evt.Data.Alarm.Alarm = types.ManagedObjectReference{Type: "Alarm", Value: "alarm-11"}
+	evt.Data.From = "yellow"
+	evt.Data.To = to
+	if vm.Value != "" {
+		evt.Data.Vm = &types.VmEventArgument{Vm: vm}
+	}
+
+	body, err := json.Marshal(evt)
+	if err != nil {
+		t.Fatalf("marshalling event: %v", err)
+	}
+
+	return body
+}
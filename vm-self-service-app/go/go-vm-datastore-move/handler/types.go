@@ -0,0 +1,46 @@
+package function
+
+import (
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/pbm"
+	"github.com/vmware/govmomi/vapi/rest"
+	"github.com/vmware/govmomi/vapi/tags"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// vcConfig represents the toml vcconfig file
+type vcConfig struct {
+	VCenter struct {
+		Server   string
+		User     string
+		Password string
+		Insecure bool
+	}
+	Audit      auditConfig
+	Approval   approvalConfig
+	Schedule   scheduleConfig
+	Automation automationConfig
+	Limits     limitsConfig
+	Placement  placementConfig
+	Evacuation evacuationConfig
+	Preflight  preflightConfig
+}
+
+// vsClient stores vSphere connection information. The REST client and tag
+// manager are only set when tags are used, the storage policy client when
+// storage policies are checked.
+type vsClient struct {
+	govmomi *govmomi.Client
+	rest    *rest.Client
+	tagMgr  *tags.Manager
+	pbm     *pbm.Client
+	// reserved is the space on each datastore taken by the relocations
+	// planned with the client, so that planning several doesn't overfill one.
+	reserved map[types.ManagedObjectReference]int64
+}
+
+// cloudEvent stores incoming event data.
+type cloudEvent struct {
+	ID   string
+	Data types.AlarmStatusChangedEvent
+}
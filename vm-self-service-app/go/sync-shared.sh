@@ -0,0 +1,26 @@
+#!/bin/sh
+# sync-shared.sh copies the files both functions share from the tagger's
+# handler, which holds the canonical copy, to the datastore move's. OpenFaaS
+# builds each function from its handler directory alone, so they can't import
+# a package next to them. Edit the tagger's copy, then run this script, or
+# go generate in the datastore move's handler.
+set -e
+cd "$(dirname "$0")"
+
+src=go-vm-config-tagger/handler
+dst=go-vm-datastore-move/handler
+
+for f in \
+	audit.go \
+	automation.go \
+	limits.go \
+	limits_test.go \
+	logger.go \
+	store.go \
+	tracing.go
+do
+	{
+		printf '// Code generated by sync-shared.sh from %s/%s. DO NOT EDIT.\n\n' "$src" "$f"
+		cat "$src/$f"
+	} >"$dst/$f"
+done